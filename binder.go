@@ -1,9 +1,12 @@
 package lua
 
 import (
+	"fmt"
+	"reflect"
 	"strings"
 
 	"github.com/krakend/binder"
+	glua "github.com/yuin/gopher-lua"
 )
 
 type Binder = binder.Binder
@@ -13,12 +16,13 @@ type Handler = binder.Handler
 type BinderWrapper struct {
 	binder    *binder.Binder
 	sourceMap *SourceMap
+	state     *glua.LState
 }
 
 func NewBinderWrapper(binderOptions binder.Options) BinderWrapper {
 	b := binder.New(binderOptions)
 	m := NewSourceMap()
-	return BinderWrapper{b, &m}
+	return BinderWrapper{b, &m, binderState(b)}
 }
 
 func (b BinderWrapper) GetBinder() *binder.Binder {
@@ -53,3 +57,64 @@ func (b BinderWrapper) WithCode(key, src string) error {
 	}
 	return nil
 }
+
+// Call executes a compiled chunk. Nil chunks are ignored
+func (b BinderWrapper) Call(proto *glua.FunctionProto) error {
+	return ToError(b.pcall(proto), b.sourceMap)
+}
+
+func (b BinderWrapper) pcall(proto *glua.FunctionProto) error {
+	if proto == nil {
+		return nil
+	}
+	b.state.Push(b.state.NewFunctionFromProto(proto))
+	return b.state.PCall(0, 0, nil)
+}
+
+// binderState returns the lua state owned by the binder. The pool requires
+// direct access to it in order to run the compiled chunks and to reset the
+// globals between requests.
+//
+// TODO: replace it with the exported accessor once krakend/binder releases it.
+// Until then, the unexported field is read only if its layout is the expected
+// one (see errStateFields), so a change in the binder is reported by Parse and
+// NewPool instead of corrupting the states.
+func binderState(b *binder.Binder) *glua.LState {
+	if errStateFields != nil {
+		return nil
+	}
+	return unexportedState(reflect.ValueOf(b))
+}
+
+// PushNil pushes a nil result. The binder only pushes strings, numbers, bools
+// and userdata, so a placeholder is pushed and replaced in the stack. The
+// placeholder (false) is kept if the state of the binder is not accessible
+func PushNil(c *binder.Context) {
+	c.Push().Bool(false)
+	if errStateFields == nil {
+		unexportedState(reflect.ValueOf(c)).Replace(-1, glua.LNil)
+	}
+}
+
+const stateField = "state"
+
+func unexportedState(v reflect.Value) *glua.LState {
+	return (*glua.LState)(v.Elem().FieldByName(stateField).UnsafePointer())
+}
+
+// errStateFields is the error of the layout check of the binder types. Parse
+// and the pools return it, so the configs are rejected instead of panicking
+var errStateFields = checkStateFields()
+
+// checkStateFields verifies the binder types keep the lua state where
+// unexportedState expects it
+func checkStateFields() error {
+	want := reflect.TypeOf(&glua.LState{})
+	for _, t := range []reflect.Type{reflect.TypeOf(binder.Binder{}), reflect.TypeOf(binder.Context{})} {
+		f, ok := t.FieldByName(stateField)
+		if !ok || f.Type != want {
+			return fmt.Errorf("lua: unsupported version of github.com/krakend/binder: %s has no %s field of type %s", t, stateField, want)
+		}
+	}
+	return nil
+}
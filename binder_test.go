@@ -0,0 +1,48 @@
+package lua
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/krakend/binder"
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/logging"
+)
+
+func TestCheckStateFields(t *testing.T) {
+	if err := checkStateFields(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStateFieldsError(t *testing.T) {
+	defer func(err error) { errStateFields = err }(errStateFields)
+	errStateFields = errors.New("unsupported binder")
+
+	in := config.ExtraConfig{"1234": map[string]interface{}{"pre": "local a = 1"}}
+	if _, err := Parse(logging.NoOp, in, "1234"); err != errStateFields {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	p := NewPool(&Config{PreCode: "local a = 1", SourceLoader: onceLoader{}}, func(*VM) {})
+	if _, err := p.Get(context.Background()); err != errStateFields {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestPushNil(t *testing.T) {
+	b := binder.New(binder.Options{SkipOpenLibs: true})
+	defer b.Close()
+
+	b.Func("nothing", func(c *binder.Context) error {
+		PushNil(c)
+		c.Push().String("second")
+		return nil
+	})
+
+	if err := b.DoString(`local a, b = nothing()
+if a ~= nil or b ~= "second" then error("unexpected results") end`); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,189 @@
+package lua
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/krakend/binder"
+)
+
+// DefaultCacheMaxEntries is the number of entries kept by a shared cache when
+// the config does not define it
+const DefaultCacheMaxEntries = 1000
+
+// ErrNotANumber is returned when incrementing a cached value that is not a number
+var ErrNotANumber = errors.New("lua: the cached value is not a number")
+
+// sharedCaches holds the caches of the process by namespace, so they outlive
+// the VMs and the pools of the configs using them
+var sharedCaches sync.Map
+
+// SharedCache is a concurrency safe key/value store shared by all the VMs of
+// the configs with the same namespace. The entries may expire and, when the
+// cache is full, the least recently used ones are evicted.
+type SharedCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	entries    map[string]*list.Element
+	lru        *list.List
+}
+
+type cacheEntry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+// NewSharedCache returns an empty cache holding up to maxEntries entries
+func NewSharedCache(maxEntries int) *SharedCache {
+	if maxEntries <= 0 {
+		maxEntries = DefaultCacheMaxEntries
+	}
+	return &SharedCache{
+		maxEntries: maxEntries,
+		entries:    map[string]*list.Element{},
+		lru:        list.New(),
+	}
+}
+
+// SharedCacheOf returns the cache of the VM owning the binder or a process-wide
+// one for binders not created by a pool
+func SharedCacheOf(b *binder.Binder) *SharedCache {
+	if vm := vmOf(b); vm != nil && vm.cache != nil {
+		return vm.cache
+	}
+	return sharedCache("", DefaultCacheMaxEntries)
+}
+
+func sharedCache(namespace string, maxEntries int) *SharedCache {
+	if c, ok := sharedCaches.Load(namespace); ok {
+		return c.(*SharedCache)
+	}
+	c, _ := sharedCaches.LoadOrStore(namespace, NewSharedCache(maxEntries))
+	return c.(*SharedCache)
+}
+
+// parseSharedCache returns the cache of the config block. Unless the
+// shared_cache block sets a namespace, every parsed config block gets its own
+// one, even if its content is the same as the one of other blocks. The blocks
+// sharing a namespace can not set different max_entries
+func parseSharedCache(c map[string]interface{}) (*SharedCache, error) {
+	cfg, _ := c["shared_cache"].(map[string]interface{})
+
+	maxEntries := DefaultCacheMaxEntries
+	v, hasMax := cfg["max_entries"].(float64)
+	hasMax = hasMax && v > 0
+	if hasMax {
+		maxEntries = int(v)
+	}
+
+	namespace, _ := cfg["namespace"].(string)
+	if namespace == "" {
+		return NewSharedCache(maxEntries), nil
+	}
+	cache := sharedCache(namespace, maxEntries)
+	if hasMax && cache.maxEntries != maxEntries {
+		return nil, ErrSharedCacheConflict(namespace)
+	}
+	return cache, nil
+}
+
+// Get returns the value of the key, if present and not expired
+func (c *SharedCache) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e := c.lookup(key)
+	if e == nil {
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	return e.Value.(*cacheEntry).value, true
+}
+
+// Set stores the value. A ttl of 0 means the entry does not expire
+func (c *SharedCache) Set(key string, value interface{}, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.set(key, value, ttl)
+}
+
+// Delete removes the key
+func (c *SharedCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+	}
+}
+
+// Incr adds delta to the number stored in the key and returns the result. Missing
+// keys are created with the given ttl, while the existing ones keep theirs
+func (c *SharedCache) Incr(key string, delta float64, ttl time.Duration) (float64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e := c.lookup(key)
+	if e == nil {
+		c.set(key, delta, ttl)
+		return delta, nil
+	}
+
+	entry := e.Value.(*cacheEntry)
+	n, ok := entry.value.(float64)
+	if !ok {
+		return 0, ErrNotANumber
+	}
+	entry.value = n + delta
+	c.lru.MoveToFront(e)
+	return n + delta, nil
+}
+
+// Len returns the number of entries, including the expired ones not evicted yet
+func (c *SharedCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lru.Len()
+}
+
+func (c *SharedCache) lookup(key string) *list.Element {
+	e, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	if expires := e.Value.(*cacheEntry).expires; !expires.IsZero() && time.Now().After(expires) {
+		c.remove(e)
+		return nil
+	}
+	return e
+}
+
+func (c *SharedCache) set(key string, value interface{}, ttl time.Duration) {
+	var expires time.Time
+	if ttl > 0 {
+		expires = time.Now().Add(ttl)
+	}
+
+	if e, ok := c.entries[key]; ok {
+		entry := e.Value.(*cacheEntry)
+		entry.value = value
+		entry.expires = expires
+		c.lru.MoveToFront(e)
+		return
+	}
+
+	for c.lru.Len() >= c.maxEntries {
+		c.remove(c.lru.Back())
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, value: value, expires: expires})
+}
+
+func (c *SharedCache) remove(e *list.Element) {
+	c.lru.Remove(e)
+	delete(c.entries, e.Value.(*cacheEntry).key)
+}
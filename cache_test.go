@@ -0,0 +1,95 @@
+package lua
+
+import (
+	"testing"
+	"time"
+
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/logging"
+)
+
+func TestSharedCache(t *testing.T) {
+	c := NewSharedCache(2)
+
+	c.Set("a", "foo", 0)
+	c.Set("b", 42.0, 0)
+	if v, ok := c.Get("a"); !ok || v != "foo" {
+		t.Errorf("unexpected value: %v", v)
+	}
+
+	// b is the least recently used entry
+	c.Set("c", true, 0)
+	if _, ok := c.Get("b"); ok {
+		t.Error("the least recently used entry was not evicted")
+	}
+	if c.Len() != 2 {
+		t.Errorf("unexpected number of entries: %d", c.Len())
+	}
+
+	c.Set("a", "bar", 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := c.Get("a"); ok {
+		t.Error("the entry did not expire")
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.Incr("counter", 2, 0); err != nil {
+			t.Error(err)
+		}
+	}
+	if v, _ := c.Get("counter"); v != 6.0 {
+		t.Errorf("unexpected counter: %v", v)
+	}
+	if _, err := c.Incr("c", 1, 0); err != ErrNotANumber {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	c.Delete("counter")
+	if _, ok := c.Get("counter"); ok {
+		t.Error("the entry was not deleted")
+	}
+}
+
+func TestParse_sharedCache(t *testing.T) {
+	parse := func(c map[string]interface{}) *SharedCache {
+		cfg, err := Parse(logging.NoOp, config.ExtraConfig{"lua": c}, "lua")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cfg.SharedCache
+	}
+
+	a := parse(map[string]interface{}{"pre": "local a = 1"})
+	if a == nil {
+		t.Fatal("no shared cache")
+	}
+	if b := parse(map[string]interface{}{"pre": "local b = 1"}); a == b {
+		t.Error("the config blocks share the cache")
+	}
+	if b := parse(map[string]interface{}{"pre": "local a = 1"}); a == b {
+		t.Error("the config blocks with the same content share the cache")
+	}
+
+	shared := map[string]interface{}{"namespace": "tokens", "max_entries": 1.0}
+	a = parse(map[string]interface{}{"pre": "local a = 1", "shared_cache": shared})
+	b := parse(map[string]interface{}{"pre": "local b = 1", "shared_cache": shared})
+	if a != b {
+		t.Error("the config blocks with the same namespace do not share the cache")
+	}
+	a.Set("a", "foo", 0)
+	a.Set("b", "bar", 0)
+	if a.Len() != 1 {
+		t.Errorf("unexpected number of entries: %d", a.Len())
+	}
+
+	if b := parse(map[string]interface{}{"pre": "local c = 1", "shared_cache": map[string]interface{}{"namespace": "tokens"}}); a != b {
+		t.Error("the config block without max_entries does not share the cache")
+	}
+
+	_, err := Parse(logging.NoOp, config.ExtraConfig{"lua": map[string]interface{}{
+		"shared_cache": map[string]interface{}{"namespace": "tokens", "max_entries": 2.0},
+	}}, "lua")
+	if err != ErrSharedCacheConflict("tokens") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,81 @@
+package lua
+
+import (
+	"crypto/md5" // skipcq: GSC-G501
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"hash"
+	"sort"
+	"strings"
+)
+
+var hashFuncs = map[string]func() hash.Hash{
+	"md5":    md5.New, // skipcq: GO-S1023, GSC-G401
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+type sourceChecksum struct {
+	source    string
+	algorithm string
+	digest    string
+}
+
+// parseChecksums returns the checksums declared in the legacy md5 block and
+// in the checksums block, where the digests are prefixed by the algorithm
+// (sha256:... or sha512:...)
+func parseChecksums(c map[string]interface{}) ([]sourceChecksum, error) {
+	var res []sourceChecksum
+
+	// md5 is kept for backwards compatibility
+	if md5s, ok := c["md5"].(map[string]interface{}); ok { // skipcq: GO-S1023, GSC-G401
+		for source, v := range md5s {
+			digest, ok := v.(string)
+			if !ok {
+				return nil, ErrWrongChecksumType(source)
+			}
+			res = append(res, sourceChecksum{source: source, algorithm: "md5", digest: digest})
+		}
+	}
+
+	if checksums, ok := c["checksums"].(map[string]interface{}); ok {
+		for source, v := range checksums {
+			s, ok := v.(string)
+			if !ok {
+				return nil, ErrWrongChecksumType(source)
+			}
+			algorithm, digest, _ := strings.Cut(s, ":")
+			if _, ok := hashFuncs[algorithm]; !ok {
+				return nil, ErrWrongChecksumType(source)
+			}
+			res = append(res, sourceChecksum{source: source, algorithm: algorithm, digest: digest})
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].source == res[j].source {
+			return res[i].algorithm < res[j].algorithm
+		}
+		return res[i].source < res[j].source
+	})
+
+	return res, nil
+}
+
+func verifyChecksums(checksums []sourceChecksum, loader SourceLoader) error {
+	for _, c := range checksums {
+		content, _ := loader.Get(c.source)
+		h := hashFuncs[c.algorithm]()
+		h.Write([]byte(content))
+		if actual := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(c.digest, actual) {
+			return ErrWrongChecksum{
+				Source:    c.source,
+				Algorithm: c.algorithm,
+				Actual:    actual,
+				Expected:  c.digest,
+			}
+		}
+	}
+	return nil
+}
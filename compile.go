@@ -0,0 +1,172 @@
+package lua
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	glua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+const (
+	sourcesChunk = "sources"
+	preChunk     = "pre-script"
+	postChunk    = "post-script"
+)
+
+// Program contains the compiled sources and scripts of a config
+type Program struct {
+	Sources   *glua.FunctionProto
+	SourceMap SourceMap
+	Pre       *glua.FunctionProto
+	Post      *glua.FunctionProto
+	// Modules contains the modules available to require, by name. It is nil
+	// if the config does not define module paths
+	Modules map[string]*glua.FunctionProto
+}
+
+type programCache struct {
+	mu       sync.Mutex
+	program  *Program
+	files    []string
+	contents []string
+}
+
+// Program returns the compiled version of the config. The sources are compiled
+// again only if their content changed since the last call, so the returned
+// program is always the same unless the config is in live mode. The compiled
+// programs are cached by the configs returned by Parse and the ones used by a
+// Pool; any other config is compiled on every call.
+func (c *Config) Program() (*Program, error) {
+	if c.programs == nil {
+		return (&programCache{}).get(c)
+	}
+	return c.programs.get(c)
+}
+
+func (pc *programCache) get(cfg *Config) (*Program, error) {
+	if d, ok := cfg.SourceLoader.(dynamicLoader); ok {
+		sources, loader := d.snapshot()
+		current := *cfg
+		current.Sources = sources
+		current.SourceLoader = loader
+		cfg = &current
+	}
+
+	files := cfg.sourceFiles()
+	contents := make([]string, len(files))
+	for i, file := range files {
+		src, ok := cfg.Get(file)
+		if !ok {
+			return nil, ErrUnknownSource(file)
+		}
+		contents[i] = src
+	}
+
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	if pc.program != nil && slices.Equal(pc.files, files) && slices.Equal(pc.contents, contents) {
+		return pc.program, nil
+	}
+
+	loaded := make(onceLoader, len(contents))
+	for i, file := range files {
+		loaded[file] = contents[i]
+	}
+	for _, file := range cfg.files() {
+		if _, ok := loaded[file]; ok {
+			continue
+		}
+		if v, ok := cfg.Get(file); ok {
+			loaded[file] = v
+		}
+	}
+	if err := cfg.verify(loaded); err != nil {
+		return nil, err
+	}
+
+	p, err := compileProgram(cfg, contents)
+	if err != nil {
+		return nil, err
+	}
+	pc.program = p
+	pc.files = files
+	pc.contents = contents
+
+	return p, nil
+}
+
+// compileProgram compiles the config with the given contents of its source
+// files, in the same order as returned by cfg.sourceFiles
+func compileProgram(cfg *Config, contents []string) (*Program, error) {
+	sources, modules := contents[:len(cfg.Sources)], contents[len(cfg.Sources):]
+
+	p := &Program{SourceMap: NewSourceMap()}
+	for i, source := range cfg.Sources {
+		p.SourceMap.Append(source, sources[i])
+	}
+
+	var err error
+	if len(sources) > 0 {
+		if p.Sources, err = compile(sourcesChunk, strings.Join(sources, "\n"), &p.SourceMap); err != nil {
+			return nil, err
+		}
+	}
+	if len(cfg.ModulePaths) > 0 {
+		p.Modules = make(map[string]*glua.FunctionProto, len(cfg.modules))
+		for i, m := range cfg.modules {
+			if p.Modules[m.name], err = compile(m.file, modules[i], nil); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if cfg.PreCode != "" {
+		if p.Pre, err = compile(preChunk, cfg.PreCode, nil); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.PostCode != "" {
+		if p.Post, err = compile(postChunk, cfg.PostCode, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+func compile(name, src string, sourceMap *SourceMap) (*glua.FunctionProto, error) {
+	chunk, err := parse.Parse(strings.NewReader(src), name)
+	if err != nil {
+		return nil, toSyntaxError(name, src, err, sourceMap)
+	}
+	return glua.Compile(chunk, name)
+}
+
+func toSyntaxError(name, src string, err error, sourceMap *SourceMap) error {
+	parseErr, ok := err.(*parse.Error)
+	if !ok {
+		return err
+	}
+
+	res := ErrSyntax{
+		Source: name,
+		Line:   parseErr.Pos.Line,
+		Msg:    fmt.Sprintf("'%s': %s", parseErr.Token, strings.TrimSpace(parseErr.Message)),
+	}
+	if parseErr.Pos.Line == parse.EOF {
+		res.Line = strings.Count(strings.Trim(src, "\n"), "\n") + 1
+		res.Msg = strings.TrimSpace(parseErr.Message) + " at EOF"
+	}
+
+	if sourceMap != nil {
+		if affectedScript, relativeLine, err := sourceMap.AffectedSource(res.Line); err == nil {
+			res.Source = affectedScript
+			res.Line = relativeLine
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,77 @@
+package lua
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/logging"
+)
+
+func TestConfig_Program_live(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_program_live")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	source := tmpfile.Name()
+	defer os.Remove(source)
+
+	if _, err := tmpfile.WriteString(`function foo() return 1 end`); err != nil {
+		t.Error(err)
+		return
+	}
+	tmpfile.Close()
+
+	in := config.ExtraConfig{
+		"1234": map[string]interface{}{
+			"sources": []interface{}{source},
+			"pre":     "foo()",
+			"live":    true,
+		},
+	}
+	cfg, err := Parse(logging.NoOp, in, "1234")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	defer closeLoader(cfg)
+
+	p1, err := cfg.Program()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if p1.Sources == nil || p1.Pre == nil || p1.Post != nil {
+		t.Errorf("unexpected program: %+v", p1)
+	}
+
+	p2, _ := cfg.Program()
+	if p1 != p2 {
+		t.Error("the program was compiled again without changes in the sources")
+	}
+
+	if err := os.WriteFile(source, []byte(`function foo() return 2 end`), 0644); err != nil {
+		t.Error(err)
+		return
+	}
+
+	var p3 *Program
+	if !eventually(func() bool {
+		p3, _ = cfg.Program()
+		return p3 != p1
+	}) {
+		t.Error("the program was not compiled again after changing the sources")
+	}
+
+	if err := os.WriteFile(source, []byte(`function foo() retrun 2 end`), 0644); err != nil {
+		t.Error(err)
+		return
+	}
+	time.Sleep(3 * reloadDelay)
+
+	if p4, err := cfg.Program(); err != nil || p4 != p3 {
+		t.Errorf("the last good version was not kept: %v", err)
+	}
+}
@@ -1,24 +1,58 @@
 package lua
 
 import (
-	"bytes"
-	"crypto/md5" // skipcq: GSC-G501
-	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
+	"net/http"
 	"os"
+	"time"
 
 	"github.com/luraproject/lura/v2/config"
 	"github.com/luraproject/lura/v2/logging"
 )
 
 type Config struct {
-	Sources       []string
-	PreCode       string
-	PostCode      string
-	SkipNext      bool
-	AllowOpenLibs bool
-	SourceLoader  SourceLoader
+	Sources          []string
+	ModulePaths      []string
+	PreCode          string
+	PostCode         string
+	SkipNext         bool
+	AllowOpenLibs    bool
+	OpenLibs         []string
+	DeniedFunctions  []string
+	SourceLoader     SourceLoader
+	Pool             PoolConfig
+	Limits           Limits
+	HTTPClient       *http.Client
+	BatchConcurrency int
+	SharedCache      *SharedCache
+	Metrics          *MetricsRegistry
+	Endpoint         string
+	ExecutionMetrics ExecutionMetrics
+	sourcePatterns   []string
+	modules          []luaModule
+	checksums        []sourceChecksum
+	signatures       *signatureVerifier
+	programs         *programCache
+}
+
+// PoolConfig defines the limits of the pool of VMs created for a config
+type PoolConfig struct {
+	// MaxSize is the max number of VMs alive at the same time. 0 means no limit
+	MaxSize int
+	// MaxIdle is the max number of VMs kept for later requests
+	MaxIdle int
+}
+
+// Close releases the resources held by the config, like the watcher of the
+// sources in live mode. The config keeps serving the last loaded version of the
+// sources after closing it
+func (c *Config) Close() error {
+	if closer, ok := c.SourceLoader.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
 }
 
 func (c *Config) Get(k string) (string, bool) {
@@ -29,12 +63,56 @@ type SourceLoader interface {
 	Get(string) (string, bool)
 }
 
+// dynamicLoader is implemented by the loaders able to change the list of
+// sources, returning it along with a consistent view of their contents
+type dynamicLoader interface {
+	snapshot() ([]string, SourceLoader)
+}
+
+// sourceFiles returns the files with lua code: the sources and the modules
+func (c *Config) sourceFiles() []string {
+	if len(c.modules) == 0 {
+		return c.Sources
+	}
+	res := make([]string, 0, len(c.Sources)+len(c.modules))
+	res = append(res, c.Sources...)
+	for _, m := range c.modules {
+		res = append(res, m.file)
+	}
+	return res
+}
+
+// files returns the files to load: the source files and, if the config
+// requires signed sources, their signatures
+func (c *Config) files() []string {
+	files := c.sourceFiles()
+	if c.signatures == nil {
+		return files
+	}
+	res := make([]string, 0, 2*len(files))
+	for _, file := range files {
+		res = append(res, file, signatureFile(file))
+	}
+	return res
+}
+
+// verify checks the integrity of the loaded source files
+func (c *Config) verify(loader SourceLoader) error {
+	if err := verifyChecksums(c.checksums, loader); err != nil {
+		return err
+	}
+	return c.signatures.verify(c.sourceFiles(), loader)
+}
+
 func Parse(l logging.Logger, e config.ExtraConfig, namespace string) (Config, error) { // skipcq: GO-R1005
-	res := Config{}
+	res := Config{Pool: PoolConfig{MaxIdle: DefaultPoolMaxIdle}, programs: &programCache{}}
 	v, ok := e[namespace]
 	if !ok {
 		return res, ErrNoExtraConfig
 	}
+	if errStateFields != nil {
+		return res, errStateFields
+	}
 	c, ok := v.(map[string]interface{})
 	if !ok {
 		return res, ErrWrongExtraConfig
@@ -51,6 +129,75 @@ func Parse(l logging.Logger, e config.ExtraConfig, namespace string) (Config, er
 	if b, ok := c["allow_open_libs"].(bool); ok && b {
 		res.AllowOpenLibs = b
 	}
+	if libs, ok := c["open_libs"].([]interface{}); ok {
+		for _, lib := range libs {
+			name, _ := lib.(string)
+			if _, ok := standardLibs[name]; !ok {
+				return res, ErrUnknownLib(name)
+			}
+			res.OpenLibs = append(res.OpenLibs, name)
+		}
+	}
+	if !res.AllowOpenLibs {
+		res.DeniedFunctions = DefaultDeniedFunctions
+	}
+	if denied, ok := c["denied_functions"].([]interface{}); ok {
+		res.DeniedFunctions = make([]string, 0, len(denied))
+		for _, fn := range denied {
+			if name, ok := fn.(string); ok {
+				res.DeniedFunctions = append(res.DeniedFunctions, name)
+			}
+		}
+	}
+
+	if v, ok := c["timeout"].(string); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return res, fmt.Errorf("lua: wrong timeout: %w", err)
+		}
+		res.Limits.Timeout = d
+	}
+	if v, ok := c["max_instructions"].(float64); ok && v > 0 {
+		res.Limits.MaxInstructions = int64(v)
+	}
+	if v, ok := c["registry_size"].(float64); ok && v > 0 {
+		res.Limits.RegistrySize = int(v)
+	}
+	if v, ok := c["call_stack_size"].(float64); ok && v > 0 {
+		res.Limits.CallStackSize = int(v)
+	}
+	if v, ok := c["max_data_size"].(float64); ok && v > 0 {
+		res.Limits.MaxDataSize = int(v)
+	}
+
+	httpClient, hasClient := c["http_client"].(map[string]interface{})
+	egress, hasEgress := c["egress"].(map[string]interface{})
+	if hasClient || hasEgress {
+		client, err := newHTTPClient(httpClient, egress)
+		if err != nil {
+			return res, err
+		}
+		res.HTTPClient = client
+	}
+
+	if v, ok := c["batch_concurrency"].(float64); ok && v > 0 {
+		res.BatchConcurrency = int(v)
+	}
+
+	cache, err := parseSharedCache(c)
+	if err != nil {
+		return res, err
+	}
+	res.SharedCache = cache
+
+	if pool, ok := c["pool"].(map[string]interface{}); ok {
+		if v, ok := pool["max_size"].(float64); ok && v > 0 {
+			res.Pool.MaxSize = int(v)
+		}
+		if v, ok := pool["max_idle"].(float64); ok && v >= 0 {
+			res.Pool.MaxIdle = int(v)
+		}
+	}
 
 	sources, ok := c["sources"].([]interface{})
 	if ok {
@@ -60,17 +207,50 @@ func Parse(l logging.Logger, e config.ExtraConfig, namespace string) (Config, er
 				s = append(s, t)
 			}
 		}
-		res.Sources = s
+		expanded, err := expandSources(s)
+		if err != nil {
+			return res, err
+		}
+		res.Sources = expanded
+		for _, entry := range s {
+			if isSourcePattern(entry) {
+				res.sourcePatterns = s
+				break
+			}
+		}
+	}
+
+	if paths, ok := c["module_paths"].([]interface{}); ok {
+		for _, path := range paths {
+			if t, ok := path.(string); ok {
+				res.ModulePaths = append(res.ModulePaths, t)
+			}
+		}
+		res.modules = findModules(l, res.ModulePaths)
+	}
+
+	checksums, err := parseChecksums(c)
+	if err != nil {
+		return res, err
+	}
+	res.checksums = checksums
+
+	if res.signatures, err = parseSignatures(c); err != nil {
+		return res, err
 	}
 
 	if b, ok := c["live"].(bool); ok && b {
-		res.SourceLoader = liveLoader{}
+		res.SourceLoader = newLiveLoader(l, &res)
+		if _, err := res.Program(); err != nil {
+			res.Close()
+			return res, err
+		}
 		return res, nil
 	}
 
 	loader := map[string]string{}
 
-	for _, source := range res.Sources {
+	for _, source := range res.files() {
 		b, err := os.ReadFile(source)
 		if err != nil {
 			l.Error("[Lua] Opening the source file:", err.Error())
@@ -80,34 +260,8 @@ func Parse(l logging.Logger, e config.ExtraConfig, namespace string) (Config, er
 	}
 	res.SourceLoader = onceLoader(loader)
 
-	// TODO: at some point we might want to change the hashing
-	// function, but we need to do it in a backards compat mode:
-	checksums, ok := c["md5"].(map[string]interface{}) // skipcq: GO-S1023, GSC-G401
-	if !ok {
-		return res, nil
-	}
-
-	for source, c := range checksums {
-		checksum, ok := c.(string)
-		if !ok {
-			return res, ErrWrongChecksumType(source)
-		}
-		content, _ := res.SourceLoader.Get(source)
-		hash := md5.New() // skipcq: GO-S1023, GSC-G401
-		if _, err := io.Copy(hash, bytes.NewBuffer([]byte(content))); err != nil {
-			return res, err
-		}
-		hashInBytes := hash.Sum(nil)[:16]
-		if actual := hex.EncodeToString(hashInBytes); checksum != actual {
-			return res, ErrWrongChecksum{
-				Source:   source,
-				Actual:   actual,
-				Expected: checksum,
-			}
-		}
-	}
-
-	return res, nil
+	_, err = res.Program()
+	return res, err
 }
 
 type onceLoader map[string]string
@@ -130,4 +284,5 @@ func (liveLoader) Get(k string) (string, bool) {
 var (
 	ErrNoExtraConfig    = errors.New("no extra config")
 	ErrWrongExtraConfig = errors.New("wrong extra config")
+	ErrNoPublicKeys     = errors.New("lua: no public keys to verify the signatures")
 )
@@ -2,7 +2,10 @@ package lua
 
 import (
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/luraproject/lura/v2/config"
 	"github.com/luraproject/lura/v2/logging"
@@ -19,8 +22,8 @@ func TestParse(t *testing.T) {
 			"md5": map[string]interface{}{
 				source: "49ae50f58e35f4821ad4550e1a4d1de0",
 			},
-			"pre":       "pre",
-			"post":      "post",
+			"pre":       "pre()",
+			"post":      "post()",
 			"skip_next": true,
 		},
 	}
@@ -36,14 +39,263 @@ func TestParse(t *testing.T) {
 	if !cfg.SkipNext {
 		t.Errorf("the skip next flag is not enabled")
 	}
-	if cfg.PreCode != "pre" {
+	if cfg.PreCode != "pre()" {
 		t.Errorf("wrong pre code %s", cfg.PreCode)
 	}
-	if cfg.PostCode != "post" {
+	if cfg.PostCode != "post()" {
 		t.Errorf("wrong post code %s", cfg.PostCode)
 	}
 }
 
+func TestParse_checksums(t *testing.T) {
+	source := "lua/factorial.lua"
+	for _, tc := range []struct {
+		Name          string
+		Cfg           map[string]interface{}
+		ExpectedError error
+	}{
+		{
+			Name: "sha256",
+			Cfg: map[string]interface{}{
+				"checksums": map[string]interface{}{
+					source: "sha256:a3a71efed661d86e48afc4136fb5d1bf2993489dacd6443b23abc712e1b5f01a",
+				},
+			},
+		},
+		{
+			Name: "sha512 and md5",
+			Cfg: map[string]interface{}{
+				"checksums": map[string]interface{}{
+					source: "sha512:2534c6f24ba754de0ef43f8cda8c8b926aaee3b77b8a4a2a072be3b8539a6988cca1d6da8b82fd7a721f898014e15d16b4e734775343cd61af404535f5dbe09b",
+				},
+				"md5": map[string]interface{}{
+					source: "49ae50f58e35f4821ad4550e1a4d1de0",
+				},
+			},
+		},
+		{
+			Name: "wrong md5",
+			Cfg: map[string]interface{}{
+				"md5": map[string]interface{}{
+					source: "00000000000000000000000000000000",
+				},
+			},
+			ExpectedError: ErrWrongChecksum{
+				Source:    source,
+				Algorithm: "md5",
+				Actual:    "49ae50f58e35f4821ad4550e1a4d1de0",
+				Expected:  "00000000000000000000000000000000",
+			},
+		},
+		{
+			Name: "wrong sha256",
+			Cfg: map[string]interface{}{
+				"checksums": map[string]interface{}{
+					source: "sha256:0000",
+				},
+			},
+			ExpectedError: ErrWrongChecksum{
+				Source:    source,
+				Algorithm: "sha256",
+				Actual:    "a3a71efed661d86e48afc4136fb5d1bf2993489dacd6443b23abc712e1b5f01a",
+				Expected:  "0000",
+			},
+		},
+		{
+			Name: "unknown algorithm",
+			Cfg: map[string]interface{}{
+				"checksums": map[string]interface{}{
+					source: "sha1:0000",
+				},
+			},
+			ExpectedError: ErrWrongChecksumType(source),
+		},
+		{
+			Name: "wrong type",
+			Cfg: map[string]interface{}{
+				"checksums": map[string]interface{}{
+					source: 42,
+				},
+			},
+			ExpectedError: ErrWrongChecksumType(source),
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			tc.Cfg["sources"] = []interface{}{source}
+			_, err := Parse(logging.NoOp, config.ExtraConfig{"1234": tc.Cfg}, "1234")
+			if err != tc.ExpectedError {
+				t.Errorf("unexpected error, have: %v, want: %v", err, tc.ExpectedError)
+			}
+		})
+	}
+}
+
+func TestParse_syntaxError(t *testing.T) {
+	for _, tc := range []struct {
+		Name          string
+		Cfg           map[string]interface{}
+		ExpectedError string
+	}{
+		{
+			Name: "pre",
+			Cfg: map[string]interface{}{
+				"pre": "local req = request.load()\nlokal a = 1()\nlocal b = 2",
+			},
+			ExpectedError: "'a': parse error (pre-script:L2)",
+		},
+		{
+			Name: "inline pre",
+			Cfg: map[string]interface{}{
+				"pre": "local req = request.load();lokal a = 1();local b = 2",
+			},
+			ExpectedError: "'a': parse error (pre-script:L1)",
+		},
+		{
+			Name: "inline post",
+			Cfg: map[string]interface{}{
+				"post": "local req = request.load();lokal a = 1();local b = 2",
+			},
+			ExpectedError: "'a': parse error (post-script:L1)",
+		},
+		{
+			Name: "unfinished block",
+			Cfg: map[string]interface{}{
+				"post": "if true then\nlocal b = 2",
+			},
+			ExpectedError: "syntax error at EOF (post-script:L2)",
+		},
+		{
+			Name: "sources",
+			Cfg: map[string]interface{}{
+				"sources": []interface{}{
+					"lua/factorial.lua",
+					"lua/add.lua",
+				},
+			},
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			_, err := Parse(logging.NoOp, config.ExtraConfig{"1234": tc.Cfg}, "1234")
+			if tc.ExpectedError == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if _, ok := err.(ErrSyntax); !ok {
+				t.Errorf("unexpected error type: %T", err)
+				return
+			}
+			if err.Error() != tc.ExpectedError {
+				t.Errorf("unexpected error, have: '%s', want: '%s'", err.Error(), tc.ExpectedError)
+			}
+		})
+	}
+}
+
+func TestParse_sourceSyntaxError(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "bad_syntax_*.lua")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.WriteString("function bad (n)\n  retrun n\nend"); err != nil {
+		t.Error(err)
+		return
+	}
+	tmpfile.Close()
+
+	in := config.ExtraConfig{
+		"1234": map[string]interface{}{
+			"sources": []interface{}{
+				"lua/factorial.lua",
+				tmpfile.Name(),
+			},
+		},
+	}
+	_, err = Parse(logging.NoOp, in, "1234")
+	e, ok := err.(ErrSyntax)
+	if !ok {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	if e.Source != filepath.Base(tmpfile.Name()) || e.Line != 2 {
+		t.Errorf("unexpected error: %v", e)
+	}
+}
+
+func TestParse_pool(t *testing.T) {
+	key := "1234"
+	cfg, err := Parse(logging.NoOp, config.ExtraConfig{key: map[string]interface{}{}}, key)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	if cfg.Pool.MaxSize != 0 || cfg.Pool.MaxIdle != DefaultPoolMaxIdle {
+		t.Errorf("unexpected default pool config: %+v", cfg.Pool)
+	}
+
+	in := config.ExtraConfig{
+		key: map[string]interface{}{
+			"pool": map[string]interface{}{
+				"max_size": 10.0,
+				"max_idle": 0.0,
+			},
+		},
+	}
+	cfg, err = Parse(logging.NoOp, in, key)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	if cfg.Pool.MaxSize != 10 || cfg.Pool.MaxIdle != 0 {
+		t.Errorf("unexpected pool config: %+v", cfg.Pool)
+	}
+}
+
+func TestParse_limits(t *testing.T) {
+	key := "1234"
+	in := config.ExtraConfig{
+		key: map[string]interface{}{
+			"timeout":          "150ms",
+			"max_instructions": 5000.0,
+			"registry_size":    1024.0,
+			"call_stack_size":  64.0,
+			"max_data_size":    4096.0,
+		},
+	}
+	cfg, err := Parse(logging.NoOp, in, key)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	expected := Limits{
+		Timeout:         150 * time.Millisecond,
+		MaxInstructions: 5000,
+		RegistrySize:    1024,
+		CallStackSize:   64,
+		MaxDataSize:     4096,
+	}
+	if cfg.Limits != expected {
+		t.Errorf("unexpected limits: %+v", cfg.Limits)
+	}
+}
+
+func TestParse_wrongTimeout(t *testing.T) {
+	in := config.ExtraConfig{
+		"1234": map[string]interface{}{
+			"timeout": "nope",
+			"pre":     "while true do end",
+		},
+	}
+	_, err := Parse(logging.NoOp, in, "1234")
+	if err == nil || !strings.Contains(err.Error(), "wrong timeout") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
 func TestParse_live(t *testing.T) {
 	tmpfile, err := os.CreateTemp("", "test_parse_live")
 	if err != nil {
@@ -79,6 +331,7 @@ func TestParse_live(t *testing.T) {
 		t.Errorf("unexpected error: %v", err)
 		return
 	}
+	defer closeLoader(cfg)
 
 	if src, ok := cfg.Get(source); !ok || src != initialContent {
 		t.Errorf("wrong content %s", src)
@@ -89,7 +342,11 @@ func TestParse_live(t *testing.T) {
 		return
 	}
 
-	if src, ok := cfg.Get(source); !ok || src != finalContent {
+	if !eventually(func() bool {
+		src, ok := cfg.Get(source)
+		return ok && src == finalContent
+	}) {
+		src, _ := cfg.Get(source)
 		t.Errorf("wrong content %s", src)
 	}
 }
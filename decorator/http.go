@@ -3,32 +3,70 @@ package decorator
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"errors"
+	"net"
 	"net/http"
 	"sync"
+	"syscall"
 
 	"github.com/krakend/binder"
 	lua "github.com/krakend/krakend-lua/v2"
 
 	"github.com/luraproject/lura/v2/transport/http/server"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func RegisterHTTPRequest(ctx context.Context, b *binder.Binder) {
+	RegisterHTTPRequestFunc(func() context.Context { return ctx }, b)
+}
+
+// RegisterHTTPRequestFunc registers the http tables, sending the requests with
+// the context returned by the ctx function at the moment of each call
+func RegisterHTTPRequestFunc(ctx func() context.Context, b *binder.Binder) {
 	t := b.Table("http_response")
 
-	t.Static("new", newHttpResponse(ctx))
+	client := lua.HTTPClientOf(b)
+	t.Static("new", newHttpResponse(ctx, client))
 
 	t.Dynamic("statusCode", httpStatus)
 	t.Dynamic("headers", httpHeaders)
 	t.Dynamic("headerList", httpHeaderList)
-	t.Dynamic("body", httpBody)
+	t.Dynamic("body", httpBody(lua.DataBudgetOf(b)))
 	t.Dynamic("close", httpClose)
+
+	r := b.Table("http_request")
+	r.Static("batch", batchHttpRequests(ctx, client, lua.BatchConcurrencyOf(b), lua.DataBudgetOf(b)))
 }
 
-func newHttpResponse(ctx context.Context) func(*binder.Context) error {
+// newHttpResponse accepts an options table (see httpCall) or the positional
+// url, method, body and headers arguments
+func newHttpResponse(ctxFunc func() context.Context, client *http.Client) func(*binder.Context) error {
 	return func(c *binder.Context) error {
+		ctx := ctxFunc()
+		if c.Top() == 1 {
+			if spec, ok := c.Arg(1).Any().(*lua.NativeTable); ok {
+				call, err := newHTTPCall(spec)
+				if err != nil {
+					return err
+				}
+				resp, err := call.do(ctx, client)
+				if err != nil && call.returnErrors {
+					lua.PushNil(c)
+					c.Push().Data(&lua.Table{Data: transportError(err)}, "luaTable")
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				c.Push().Data(resp, "http_response")
+				return nil
+			}
+		}
+
 		if c.Top() == 0 || c.Top() == 2 {
-			return errors.New("need 1, 3 or 4 arguments")
+			return errors.New("need an options table or 1, 3 or 4 arguments")
 		}
 
 		URL := c.Arg(1).String()
@@ -50,26 +88,15 @@ func newHttpResponse(ctx context.Context) func(*binder.Context) error {
 			}
 
 			if c.Top() == 4 {
-				headers, ok := c.Arg(4).Any().(*lua.NativeTable)
-
-				if ok {
-					headers.ForEach(func(key, value lua.NativeValue) {
-						switch l := value.(type) {
-						case lua.NativeString:
-							req.Header.Add(key.String(), l.String())
-						case *lua.NativeTable:
-							l.ForEach(func(_, v lua.NativeValue) {
-								req.Header.Add(key.String(), v.String())
-							})
-						}
-					})
+				if headers, ok := c.Arg(4).Any().(*lua.NativeTable); ok {
+					addHeaders(req, headers)
 				}
 			}
 		}
 
-		resp, err := executeHttpRequest(req.WithContext(ctx))
+		resp, err := executeHttpRequest(client, req.WithContext(ctx))
 		if err != nil {
-			return err
+			return httpError(err)
 		}
 		if resp == nil {
 			return ErrResponseExpected
@@ -79,9 +106,87 @@ func newHttpResponse(ctx context.Context) func(*binder.Context) error {
 	}
 }
 
-func executeHttpRequest(r *http.Request) (*http.Response, error) {
+func addHeaders(req *http.Request, headers *lua.NativeTable) {
+	headers.ForEach(func(key, value lua.NativeValue) {
+		switch l := value.(type) {
+		case lua.NativeString:
+			req.Header.Add(key.String(), l.String())
+		case *lua.NativeTable:
+			l.ForEach(func(_, v lua.NativeValue) {
+				req.Header.Add(key.String(), v.String())
+			})
+		}
+	})
+}
+
+// httpError unwraps the errors of the egress policy, so the scripts get a
+// clear message
+func httpError(err error) error {
+	var denied lua.ErrEgressDenied
+	if errors.As(err, &denied) {
+		return denied
+	}
+	return err
+}
+
+// transportError describes the errors returned to the scripts when they opt in
+// with the return_errors option, so they can implement fallbacks
+func transportError(err error) map[string]interface{} {
+	kind := "transport"
+	var (
+		denied    lua.ErrEgressDenied
+		dnsErr    *net.DNSError
+		netErr    net.Error
+		certErr   *tls.CertificateVerificationError
+		recordErr tls.RecordHeaderError
+	)
+	timeout := errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+
+	switch {
+	case timeout:
+		kind = "timeout"
+	case errors.As(err, &denied):
+		kind = "egress_denied"
+		err = denied
+	case errors.As(err, &dnsErr):
+		kind = "dns"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		kind = "connection_refused"
+	case errors.Is(err, syscall.ECONNRESET):
+		kind = "connection_reset"
+	case errors.As(err, &certErr), errors.As(err, &recordErr):
+		kind = "tls"
+	case errors.Is(err, context.Canceled):
+		kind = "canceled"
+	}
+
+	return map[string]interface{}{
+		"kind":    kind,
+		"message": err.Error(),
+		"timeout": timeout,
+	}
+}
+
+// executeHttpRequest sends the request within a client span, propagating it
+// to the remote service
+func executeHttpRequest(client *http.Client, r *http.Request) (*http.Response, error) {
+	ctx, span := lua.StartSpan(r.Context(), "lua http "+r.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", r.Method),
+			attribute.String("url.full", r.URL.String()),
+		),
+	)
+	r = r.WithContext(ctx)
+	lua.InjectTraceHeaders(ctx, r.Header)
 	r.Header.Add("User-Agent", server.UserAgentHeaderValue[0])
-	return http.DefaultClient.Do(r)
+
+	resp, err := client.Do(r)
+	if err == nil {
+		span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	}
+	lua.EndSpan(span, err)
+	return resp, err
 }
 
 func pushHTTPResponse(c *binder.Context, r *http.Response) {
@@ -136,14 +241,20 @@ func httpHeaderList(c *binder.Context) error {
 	return nil
 }
 
-func httpBody(c *binder.Context) error {
-	resp, ok := c.Arg(1).Data().(*lua.HttpResponse)
-	if !ok {
-		return ErrResponseExpected
-	}
-	c.Push().String(resp.Body())
+func httpBody(budget *lua.DataBudget) func(*binder.Context) error {
+	return func(c *binder.Context) error {
+		resp, ok := c.Arg(1).Data().(*lua.HttpResponse)
+		if !ok {
+			return ErrResponseExpected
+		}
+		body, err := resp.ReadBody(budget)
+		if err != nil {
+			return err
+		}
+		c.Push().String(body)
 
-	return nil
+		return nil
+	}
 }
 
 func httpClose(c *binder.Context) error {
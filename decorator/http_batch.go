@@ -0,0 +1,80 @@
+package decorator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/krakend/binder"
+	lua "github.com/krakend/krakend-lua/v2"
+)
+
+var errBatchExpected = errors.New("batch expected: a table of calls")
+
+// batchHttpRequests executes the calls concurrently, up to the given limit, and
+// pushes a luaList with, in the same order, the http_response of every call or
+// a luaTable describing the error that aborted it (see transportError). The
+// bodies are read before returning, so the timeouts of the calls cover them
+// too, and their size is charged to the data budget.
+func batchHttpRequests(ctxFunc func() context.Context, client *http.Client, limit int, budget *lua.DataBudget) func(*binder.Context) error {
+	return func(c *binder.Context) error {
+		if c.Top() != 1 {
+			return ErrNeedsArguments
+		}
+		specs, ok := c.Arg(1).Any().(*lua.NativeTable)
+		if !ok {
+			return errBatchExpected
+		}
+
+		calls := make([]httpCall, specs.Len())
+		for i := range calls {
+			spec, ok := specs.RawGetInt(i + 1).(*lua.NativeTable)
+			if !ok {
+				return fmt.Errorf("batch call #%d: table expected", i+1)
+			}
+			call, err := newHTTPCall(spec)
+			if err != nil {
+				return fmt.Errorf("batch call #%d: %w", i+1, err)
+			}
+			calls[i] = call
+		}
+
+		ctx := ctxFunc()
+		results := make([]interface{}, len(calls))
+		sem := make(chan struct{}, limit)
+		var wg sync.WaitGroup
+		for i := range calls {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(i int) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				resp, err := calls[i].do(ctx, client)
+				if err == nil {
+					err = resp.Read()
+				}
+				if err != nil {
+					results[i] = transportError(err)
+					return
+				}
+				results[i] = resp
+			}(i)
+		}
+		wg.Wait()
+
+		for _, r := range results {
+			if resp, ok := r.(*lua.HttpResponse); ok {
+				if _, err := resp.ReadBody(budget); err != nil {
+					return err
+				}
+			}
+		}
+
+		c.Push().Data(&lua.List{Data: results}, "luaList")
+		return nil
+	}
+}
@@ -0,0 +1,66 @@
+package decorator
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/krakend/binder"
+)
+
+func TestRegisterHTTPRequest_batch(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		if r.URL.Path == "/slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.Header().Set("X-Method", r.Method)
+		w.Header().Set("X-Custom", r.Header.Get("X-Custom"))
+		fmt.Fprint(w, r.URL.Path)
+	}))
+	defer ts.Close()
+
+	bindr := binder.New(binder.Options{
+		SkipOpenLibs:        true,
+		IncludeGoStackTrace: true,
+	})
+
+	RegisterLuaList(bindr)
+	RegisterLuaTable(bindr)
+	RegisterHTTPRequest(context.Background(), bindr)
+
+	code := fmt.Sprintf("local url = '%s'\n%s", ts.URL, sampleBatchCode)
+
+	start := time.Now()
+	if err := bindr.DoString(code); err != nil {
+		t.Error(err)
+	}
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("the calls were not executed concurrently: %s", elapsed)
+	}
+}
+
+const sampleBatchCode = `
+local res = http_request.batch({
+	{url = url .. "/a"},
+	{url = url .. "/b", method = "POST", body = "{}", headers = {["X-Custom"] = "foo"}},
+	{url = url .. "/slow", timeout = "150ms"},
+	{url = "http://localhost:0/unreachable"},
+})
+
+if res:len() ~= 4 then error("unexpected number of results: " .. res:len()) end
+
+local a = res:get(0)
+if a:statusCode() ~= 200 or a:body() ~= "/a" then error("unexpected first response") end
+
+local b = res:get(1)
+if b:headers("X-Method") ~= "POST" or b:headers("X-Custom") ~= "foo" or b:body() ~= "/b" then error("unexpected second response") end
+
+local slow = res:get(2)
+if slow:get("kind") ~= "timeout" or not slow:get("timeout") then error("the slow call did not time out") end
+local unreachable = res:get(3)
+if unreachable:get("kind") == "timeout" or unreachable:get("message") == nil then error("the unreachable call did not fail") end
+`
@@ -0,0 +1,141 @@
+package decorator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	lua "github.com/krakend/krakend-lua/v2"
+	glua "github.com/yuin/gopher-lua"
+)
+
+// httpCall is a request defined with an options table:
+//
+//	{url=..., method=..., headers=..., query=..., body=..., json=...,
+//	 basic_auth={user=..., password=...}, timeout=..., follow_redirects=...,
+//	 return_errors=...}
+type httpCall struct {
+	req             *http.Request
+	timeout         time.Duration
+	followRedirects bool
+	returnErrors    bool
+}
+
+func newHTTPCall(spec *lua.NativeTable) (httpCall, error) {
+	URL, ok := spec.RawGetString("url").(lua.NativeString)
+	if !ok {
+		return httpCall{}, errors.New("url expected")
+	}
+
+	method := http.MethodGet
+	if v, ok := spec.RawGetString("method").(lua.NativeString); ok {
+		method = strings.ToUpper(string(v))
+	}
+
+	var body io.Reader = http.NoBody
+	contentType := ""
+	if v, ok := spec.RawGetString("body").(lua.NativeString); ok {
+		body = bytes.NewBufferString(string(v))
+	}
+	if v := spec.RawGetString("json"); v != glua.LNil {
+		data, err := jsonValue(v)
+		if err != nil {
+			return httpCall{}, err
+		}
+		b, err := json.Marshal(data)
+		if err != nil {
+			return httpCall{}, err
+		}
+		body = bytes.NewBuffer(b)
+		contentType = "application/json"
+	}
+
+	req, err := http.NewRequest(method, string(URL), body)
+	if err != nil {
+		return httpCall{}, err
+	}
+	call := httpCall{req: req, followRedirects: true}
+
+	if query, ok := spec.RawGetString("query").(*lua.NativeTable); ok {
+		q := req.URL.Query()
+		query.ForEach(func(key, value lua.NativeValue) {
+			switch l := value.(type) {
+			case *lua.NativeTable:
+				l.ForEach(func(_, v lua.NativeValue) {
+					q.Add(key.String(), v.String())
+				})
+			default:
+				q.Add(key.String(), l.String())
+			}
+		})
+		req.URL.RawQuery = q.Encode()
+	}
+
+	if headers, ok := spec.RawGetString("headers").(*lua.NativeTable); ok {
+		addHeaders(req, headers)
+	}
+	if contentType != "" && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	if auth, ok := spec.RawGetString("basic_auth").(*lua.NativeTable); ok {
+		user, _ := auth.RawGetString("user").(lua.NativeString)
+		password, _ := auth.RawGetString("password").(lua.NativeString)
+		req.SetBasicAuth(string(user), string(password))
+	}
+
+	if v, ok := spec.RawGetString("timeout").(lua.NativeString); ok {
+		if call.timeout, err = time.ParseDuration(string(v)); err != nil {
+			return httpCall{}, err
+		}
+	}
+
+	if v, ok := spec.RawGetString("follow_redirects").(lua.NativeBool); ok {
+		call.followRedirects = bool(v)
+	}
+	if v, ok := spec.RawGetString("return_errors").(lua.NativeBool); ok {
+		call.returnErrors = bool(v)
+	}
+
+	return call, nil
+}
+
+// do executes the call. When the call has a timeout, the body is read before
+// returning, so the timeout covers it too.
+func (h httpCall) do(ctx context.Context, client *http.Client) (*lua.HttpResponse, error) {
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+
+	if !h.followRedirects {
+		c := *client
+		c.CheckRedirect = func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+		client = &c
+	}
+
+	resp, err := executeHttpRequest(client, h.req.WithContext(ctx))
+	if err != nil {
+		return nil, httpError(err)
+	}
+	if resp == nil {
+		return nil, ErrResponseExpected
+	}
+
+	r := &lua.HttpResponse{Once: new(sync.Once), R: resp}
+	if h.timeout > 0 {
+		if err := r.Read(); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
@@ -7,6 +7,9 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
 
 	"github.com/krakend/binder"
 )
@@ -102,3 +105,149 @@ print(hr:get(1))
 print(r:body())
 r:close()
 `
+
+func ExampleRegisterHTTPRequest_options() {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+		user, password, _ := r.BasicAuth()
+		body, _ := io.ReadAll(r.Body)
+		r.Body.Close()
+		fmt.Println(strings.Join([]string{r.Method, r.URL.RawQuery, user, password, r.Header.Get("Content-Type"), r.Header.Get("X-Foo")}, "|"))
+		fmt.Println(string(body))
+		fmt.Fprint(w, "Hello, client")
+	}))
+	defer ts.Close()
+
+	bindr := binder.New(binder.Options{
+		SkipOpenLibs:        true,
+		IncludeGoStackTrace: true,
+	})
+
+	RegisterLuaList(bindr)
+	RegisterLuaTable(bindr)
+	RegisterHTTPRequest(context.Background(), bindr)
+
+	code := fmt.Sprintf("local url = '%s'\n%s", ts.URL, sampleOptionsLuaCode)
+
+	if err := bindr.DoString(code); err != nil {
+		fmt.Println(err.Error())
+	}
+
+	// output:
+	// POST|a=1&b=x&b=y|me|secret|application/json|bar
+	// {"foo":["bar",42]}
+	// 200
+	// Hello, client
+	// GET|||||
+	//
+	// 200
+	// 302
+	// /
+	// timeout
+}
+
+const sampleOptionsLuaCode = `local r = http_response.new{
+	url = url .. "/post",
+	method = "post",
+	query = {a = "1", b = {"x", "y"}},
+	headers = {["X-Foo"] = "bar"},
+	basic_auth = {user = "me", password = "secret"},
+	json = {foo = {"bar", 42}},
+	timeout = "1s",
+}
+print(r:statusCode())
+print(r:body())
+
+r = http_response.new{url = url .. "/redirect"}
+print(r:statusCode())
+
+r = http_response.new{url = url .. "/redirect", follow_redirects = false}
+print(r:statusCode())
+print(r:headers("Location"))
+
+local ok = pcall(http_response.new, {url = url, timeout = "1ns"})
+if not ok then print("timeout") end
+`
+
+func ExampleRegisterHTTPRequest_returnErrors() {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		fmt.Fprint(w, "Hello, client")
+	}))
+	defer ts.Close()
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	bindr := binder.New(binder.Options{
+		SkipOpenLibs:        true,
+		IncludeGoStackTrace: true,
+	})
+
+	RegisterLuaList(bindr)
+	RegisterLuaTable(bindr)
+	RegisterHTTPRequest(context.Background(), bindr)
+
+	code := fmt.Sprintf("local url = '%s'\nlocal closed = '%s'\n%s", ts.URL, closed.URL, sampleReturnErrorsLuaCode)
+
+	if err := bindr.DoString(code); err != nil {
+		fmt.Println(err.Error())
+	}
+
+	// output:
+	// Hello, client
+	// true
+	// connection_refused
+	// false
+	// timeout
+	// true
+	// aborted
+}
+
+const sampleReturnErrorsLuaCode = `local r, err = http_response.new{url = url, return_errors = true}
+print(r:body())
+print(err == nil)
+
+r, err = http_response.new{url = closed, return_errors = true}
+if r == nil then
+	print(err:get("kind"))
+	print(err:get("timeout"))
+end
+
+r, err = http_response.new{url = url, timeout = "10ms", return_errors = true}
+if r == nil then
+	print(err:get("kind"))
+	print(err:get("timeout"))
+end
+
+if not pcall(http_response.new, {url = closed}) then print("aborted") end
+`
+
+func TestRegisterHTTPRequest_bodyTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, "partial")
+		w.(http.Flusher).Flush()
+		time.Sleep(300 * time.Millisecond)
+		fmt.Fprint(w, "-rest")
+	}))
+	defer ts.Close()
+
+	bindr := binder.New(binder.Options{
+		SkipOpenLibs:        true,
+		IncludeGoStackTrace: true,
+	})
+
+	RegisterLuaTable(bindr)
+	RegisterHTTPRequest(context.Background(), bindr)
+
+	code := fmt.Sprintf(`local r, err = http_response.new{url = '%s', timeout = "100ms", return_errors = true}
+if r ~= nil then error("body=" .. r:body()) end
+if err:get("kind") ~= "timeout" then error("unexpected error: " .. err:get("kind")) end`, ts.URL)
+
+	if err := bindr.DoString(code); err != nil {
+		t.Error(err)
+	}
+}
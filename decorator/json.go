@@ -0,0 +1,130 @@
+package decorator
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/krakend/binder"
+	lua "github.com/krakend/krakend-lua/v2"
+	glua "github.com/yuin/gopher-lua"
+)
+
+var (
+	errUnsupportedJSONValue = errors.New("unsupported value for json encoding")
+	errTrailingJSON         = errors.New("invalid json: unexpected data after the top-level value")
+)
+
+func RegisterJSON(b *binder.Binder) {
+	budget := lua.DataBudgetOf(b)
+	t := b.Table("json")
+	t.Static("decode", jsonDecode(budget))
+	t.Static("encode", jsonEncode)
+}
+
+// jsonDecode pushes the decoded value. Objects and arrays are pushed as luaTable
+// and luaList instances, keeping the numbers as json.Number so they are
+// encoded again without losing precision.
+func jsonDecode(budget *lua.DataBudget) func(*binder.Context) error {
+	return func(c *binder.Context) error {
+		if c.Top() != 1 {
+			return ErrNeedsArguments
+		}
+		src := c.Arg(1).String()
+		if err := budget.Consume(len(src)); err != nil {
+			return err
+		}
+
+		d := json.NewDecoder(strings.NewReader(src))
+		d.UseNumber()
+		var v interface{}
+		if err := d.Decode(&v); err != nil {
+			return err
+		}
+		if err := d.Decode(&struct{}{}); err != io.EOF {
+			return errTrailingJSON
+		}
+
+		switch t := v.(type) {
+		case nil:
+			c.Push().Data(nil, "luaNil")
+		case map[string]interface{}:
+			c.Push().Data(&lua.Table{Data: t}, "luaTable")
+		case []interface{}:
+			c.Push().Data(&lua.List{Data: t}, "luaList")
+		case string:
+			c.Push().String(t)
+		case bool:
+			c.Push().Bool(t)
+		case json.Number:
+			n, _ := t.Float64()
+			c.Push().Number(n)
+		}
+		return nil
+	}
+}
+
+func jsonEncode(c *binder.Context) error {
+	if c.Top() != 1 {
+		return ErrNeedsArguments
+	}
+
+	v, err := jsonValue(c.Arg(1).Any())
+	if err != nil {
+		return err
+	}
+
+	buf := new(bytes.Buffer)
+	e := json.NewEncoder(buf)
+	e.SetEscapeHTML(false)
+	if err := e.Encode(v); err != nil {
+		return err
+	}
+	c.Push().String(strings.TrimSuffix(buf.String(), "\n"))
+	return nil
+}
+
+// jsonValue returns the go value to encode for the lua one
+func jsonValue(value interface{}) (interface{}, error) {
+	var v interface{}
+	switch t := value.(type) {
+	case lua.NativeString:
+		v = string(t)
+	case lua.NativeNumber:
+		v = jsonNumber(float64(t))
+	case lua.NativeBool:
+		v = bool(t)
+	case *lua.NativeTable:
+		data, _ := lua.MapNativeTable(t)
+		if l, ok := data.([]interface{}); ok && len(l) == 0 {
+			data = map[string]interface{}{}
+		}
+		v = data
+	case *lua.NativeUserData:
+		switch d := t.Value.(type) {
+		case nil:
+		case *lua.Table:
+			v = d.Data
+		case *lua.List:
+			v = d.Data
+		default:
+			return nil, errUnsupportedJSONValue
+		}
+	default:
+		if t != glua.LNil {
+			return nil, errUnsupportedJSONValue
+		}
+	}
+	return v, nil
+}
+
+// jsonNumber keeps the integers without decimals
+func jsonNumber(f float64) interface{} {
+	if f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+		return int64(f)
+	}
+	return f
+}
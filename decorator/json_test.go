@@ -0,0 +1,86 @@
+package decorator
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/krakend/binder"
+)
+
+func ExampleRegisterJSON() {
+	bindr := binder.New(binder.Options{
+		SkipOpenLibs:        true,
+		IncludeGoStackTrace: true,
+	})
+
+	RegisterNil(bindr)
+	RegisterLuaTable(bindr)
+	RegisterLuaList(bindr)
+	RegisterJSON(bindr)
+
+	if err := bindr.DoString(sampleJSONCode); err != nil {
+		fmt.Println(err.Error())
+	}
+
+	// output:
+	// 1.5
+	// true
+	// b
+	// 3
+	// {"a":[1,"b",null,{"c":true}],"big":12345678901234567890,"empty":null,"float":1.5,"html":"<&>"}
+	// {"list":[1,2,3],"nested":{"null":null},"number":42,"ratio":0.25}
+	// [1,null,"<a>"]
+	// "text"
+	// 3.5
+	// null
+	// {}
+	// ok
+}
+
+const sampleJSONCode = `
+local t = json.decode('{"a":[1,"b",null,{"c":true}],"big":12345678901234567890,"empty":null,"float":1.5,"html":"<&>"}')
+print(t:get("float"))
+print(t:get("a"):get(3):get("c"))
+print(t:get("a"):get(1))
+print(t:len() - 2)
+print(json.encode(t))
+
+print(json.encode({list = {1, 2, 3}, number = 42, ratio = 0.25, nested = {null = luaNil.new()}}))
+
+local l = luaList.new()
+l:set(0, 1)
+l:set(1, luaNil.new())
+l:set(2, "<a>")
+print(json.encode(l))
+
+print(json.encode(json.decode('"text"')))
+print(json.encode(json.decode('3.5')))
+print(json.encode(json.decode('null')))
+print(json.encode({}))
+
+local ok = pcall(json.decode, '{"broken"')
+print(ok and "broken json accepted" or "ok")
+`
+
+func TestRegisterJSON_trailingData(t *testing.T) {
+	bindr := binder.New(binder.Options{
+		SkipOpenLibs:        true,
+		IncludeGoStackTrace: true,
+	})
+
+	RegisterNil(bindr)
+	RegisterLuaTable(bindr)
+	RegisterLuaList(bindr)
+	RegisterJSON(bindr)
+
+	for _, src := range []string{`{"a":1} garbage`, `{"a":1}{"b":2}`, `1 2`} {
+		err := bindr.DoString(fmt.Sprintf("json.decode(%q)", src))
+		if err == nil || !strings.Contains(err.Error(), errTrailingJSON.Error()) {
+			t.Errorf("%s: unexpected error: %v", src, err)
+		}
+	}
+	if err := bindr.DoString(`json.decode('{"a":1}  \n')`); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,45 @@
+package decorator
+
+import (
+	"github.com/krakend/binder"
+	lua "github.com/krakend/krakend-lua/v2"
+	"github.com/luraproject/lura/v2/logging"
+)
+
+// RegisterLogger adds the log table, forwarding the messages of the scripts to
+// the logger, after the given prefix
+func RegisterLogger(b *binder.Binder, l logging.Logger, prefix string) {
+	t := b.Table("log")
+	t.Static("debug", logMessage(l.Debug, prefix))
+	t.Static("info", logMessage(l.Info, prefix))
+	t.Static("warning", logMessage(l.Warning, prefix))
+	t.Static("error", logMessage(l.Error, prefix))
+	t.Static("critical", logMessage(l.Critical, prefix))
+}
+
+func logMessage(f func(...interface{}), prefix string) func(*binder.Context) error {
+	return func(c *binder.Context) error {
+		args := make([]interface{}, 0, c.Top()+1)
+		args = append(args, prefix)
+		for i := 1; i <= c.Top(); i++ {
+			args = append(args, logValue(c.Arg(i).Any()))
+		}
+		f(args...)
+		return nil
+	}
+}
+
+func logValue(v interface{}) interface{} {
+	if ud, ok := v.(*lua.NativeUserData); ok {
+		switch d := ud.Value.(type) {
+		case *lua.Table:
+			return d.Data
+		case *lua.List:
+			return d.Data
+		}
+	}
+	if lv, ok := v.(lua.NativeValue); ok {
+		return lv.String()
+	}
+	return v
+}
@@ -0,0 +1,50 @@
+package decorator
+
+import (
+	"fmt"
+
+	"github.com/krakend/binder"
+)
+
+type printLogger struct{}
+
+func (printLogger) Debug(v ...interface{})    { printLog("DEBUG:", v) }
+func (printLogger) Info(v ...interface{})     { printLog("INFO:", v) }
+func (printLogger) Warning(v ...interface{})  { printLog("WARNING:", v) }
+func (printLogger) Error(v ...interface{})    { printLog("ERROR:", v) }
+func (printLogger) Critical(v ...interface{}) { printLog("CRITICAL:", v) }
+func (printLogger) Fatal(v ...interface{})    { printLog("FATAL:", v) }
+
+func printLog(level string, v []interface{}) {
+	fmt.Println(append([]interface{}{level}, v...)...)
+}
+
+func ExampleRegisterLogger() {
+	bindr := binder.New(binder.Options{
+		SkipOpenLibs:        true,
+		IncludeGoStackTrace: true,
+	})
+
+	RegisterLuaTable(bindr)
+	RegisterLogger(bindr, printLogger{}, "[ENDPOINT: /foo][Lua]")
+
+	if err := bindr.DoString(sampleLogCode); err != nil {
+		fmt.Println(err.Error())
+	}
+
+	// output:
+	// DEBUG: [ENDPOINT: /foo][Lua] debug
+	// INFO: [ENDPOINT: /foo][Lua] answer: 42
+	// WARNING: [ENDPOINT: /foo][Lua] flag true
+	// ERROR: [ENDPOINT: /foo][Lua] map[a:b]
+	// CRITICAL: [ENDPOINT: /foo][Lua] nil
+}
+
+const sampleLogCode = `log.debug("debug")
+log.info("answer:", 42)
+log.warning("flag", true)
+local t = luaTable.new()
+t:set("a", "b")
+log.error(t)
+log.critical(nil)
+`
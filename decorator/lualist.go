@@ -8,13 +8,14 @@ import (
 )
 
 func RegisterLuaList(b *binder.Binder) {
+	budget := lua.DataBudgetOf(b)
 	list := b.Table("luaList")
 	list.Static("new", func(c *binder.Context) error {
 		c.Push().Data(&lua.List{Data: []interface{}{}}, "luaList")
 		return nil
 	})
 	list.Dynamic("get", listGet)
-	list.Dynamic("set", listSet)
+	list.Dynamic("set", listSet(budget))
 	list.Dynamic("len", listLen)
 	list.Dynamic("del", listDel)
 }
@@ -61,61 +62,70 @@ func listGet(c *binder.Context) error {
 		c.Push().Data(&lua.List{Data: t}, "luaList")
 	case map[string]interface{}:
 		c.Push().Data(&lua.Table{Data: t}, "luaTable")
+	case *lua.HttpResponse:
+		c.Push().Data(t, "http_response")
 	}
 
 	return nil
 }
 
-func listSet(c *binder.Context) error {
-	if c.Top() != 3 {
-		return ErrNeedsArguments
-	}
-	tab, ok := c.Arg(1).Data().(*lua.List)
-	if !ok {
-		return ErrResponseExpected
-	}
-	key := int(c.Arg(2).Number())
-	if key < 0 {
-		return nil
-	}
-	if key >= len(tab.Data) {
-		if cap(tab.Data) > key {
-			for i := len(tab.Data); i < key; i++ {
-				tab.Data = append(tab.Data, nil)
+func listSet(budget *lua.DataBudget) func(*binder.Context) error {
+	return func(c *binder.Context) error {
+		if c.Top() != 3 {
+			return ErrNeedsArguments
+		}
+		tab, ok := c.Arg(1).Data().(*lua.List)
+		if !ok {
+			return ErrResponseExpected
+		}
+		key := int(c.Arg(2).Number())
+		if key < 0 {
+			return nil
+		}
+		if key >= len(tab.Data) {
+			if err := budget.Consume(8 * (key + 1 - len(tab.Data))); err != nil {
+				return err
+			}
+			if cap(tab.Data) > key {
+				for i := len(tab.Data); i < key; i++ {
+					tab.Data = append(tab.Data, nil)
+				}
+			} else {
+				newData := make([]interface{}, key+1)
+				copy(newData, tab.Data)
+				tab.Data = newData
 			}
-		} else {
-			newData := make([]interface{}, key+1)
-			copy(newData, tab.Data)
-			tab.Data = newData
 		}
-	}
-	switch t := c.Arg(3).Any().(type) {
-	case lua.NativeString:
-		tab.Data[key] = c.Arg(3).String()
-	case lua.NativeNumber:
-		tab.Data[key] = c.Arg(3).Number()
-	case lua.NativeBool:
-		tab.Data[key] = c.Arg(3).Bool()
-	case *lua.NativeTable:
-		res := map[string]interface{}{}
-		t.ForEach(func(k, v lua.NativeValue) {
-			lua.ParseToTable(k, v, res)
-		})
-		tab.Data[key] = res
-	case *lua.NativeUserData:
-		if t.Value == nil {
-			tab.Data[key] = nil
-		} else {
-			switch v := t.Value.(type) {
-			case *lua.Table:
-				tab.Data[key] = v.Data
-			case *lua.List:
-				tab.Data[key] = v.Data
+		switch t := c.Arg(3).Any().(type) {
+		case lua.NativeString:
+			tab.Data[key] = c.Arg(3).String()
+		case lua.NativeNumber:
+			tab.Data[key] = c.Arg(3).Number()
+		case lua.NativeBool:
+			tab.Data[key] = c.Arg(3).Bool()
+		case *lua.NativeTable:
+			res := map[string]interface{}{}
+			t.ForEach(func(k, v lua.NativeValue) {
+				lua.ParseToTable(k, v, res)
+			})
+			tab.Data[key] = res
+		case *lua.NativeUserData:
+			if t.Value == nil {
+				tab.Data[key] = nil
+			} else {
+				switch v := t.Value.(type) {
+				case *lua.Table:
+					tab.Data[key] = v.Data
+				case *lua.List:
+					tab.Data[key] = v.Data
+				}
 			}
+			// the data of userdata values is already allocated
+			return nil
 		}
-	}
 
-	return nil
+		return budget.Consume(lua.SizeOf(tab.Data[key]))
+	}
 }
 
 func listDel(c *binder.Context) error {
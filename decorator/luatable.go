@@ -11,13 +11,14 @@ import (
 )
 
 func RegisterLuaTable(b *binder.Binder) {
+	budget := lua.DataBudgetOf(b)
 	tab := b.Table("luaTable")
 	tab.Static("new", func(c *binder.Context) error {
 		c.Push().Data(&lua.Table{Data: map[string]interface{}{}}, "luaTable")
 		return nil
 	})
 	tab.Dynamic("get", tableGet)
-	tab.Dynamic("set", tableSet)
+	tab.Dynamic("set", tableSet(budget))
 	tab.Dynamic("len", tableLen)
 	tab.Dynamic("del", tableDel)
 	tab.Dynamic("keys", tableKeys)
@@ -70,42 +71,46 @@ func tableGet(c *binder.Context) error {
 	return nil
 }
 
-func tableSet(c *binder.Context) error {
-	if c.Top() != 3 {
-		return ErrNeedsArguments
-	}
-	tab, ok := c.Arg(1).Data().(*lua.Table)
-	if !ok {
-		return ErrResponseExpected
-	}
-	key := c.Arg(2).String()
-	switch t := c.Arg(3).Any().(type) {
-	case lua.NativeString:
-		tab.Data[key] = c.Arg(3).String()
-	case lua.NativeNumber:
-		tab.Data[key] = c.Arg(3).Number()
-	case lua.NativeBool:
-		tab.Data[key] = c.Arg(3).Bool()
-	case *lua.NativeTable:
-		res := map[string]interface{}{}
-		t.ForEach(func(k, v lua.NativeValue) {
-			lua.ParseToTable(k, v, res)
-		})
-		tab.Data[key] = res
-	case *lua.NativeUserData:
-		if t.Value == nil {
-			tab.Data[key] = nil
-		} else {
-			switch v := t.Value.(type) {
-			case *lua.Table:
-				tab.Data[key] = v.Data
-			case *lua.List:
-				tab.Data[key] = v.Data
+func tableSet(budget *lua.DataBudget) func(*binder.Context) error {
+	return func(c *binder.Context) error {
+		if c.Top() != 3 {
+			return ErrNeedsArguments
+		}
+		tab, ok := c.Arg(1).Data().(*lua.Table)
+		if !ok {
+			return ErrResponseExpected
+		}
+		key := c.Arg(2).String()
+		switch t := c.Arg(3).Any().(type) {
+		case lua.NativeString:
+			tab.Data[key] = c.Arg(3).String()
+		case lua.NativeNumber:
+			tab.Data[key] = c.Arg(3).Number()
+		case lua.NativeBool:
+			tab.Data[key] = c.Arg(3).Bool()
+		case *lua.NativeTable:
+			res := map[string]interface{}{}
+			t.ForEach(func(k, v lua.NativeValue) {
+				lua.ParseToTable(k, v, res)
+			})
+			tab.Data[key] = res
+		case *lua.NativeUserData:
+			if t.Value == nil {
+				tab.Data[key] = nil
+			} else {
+				switch v := t.Value.(type) {
+				case *lua.Table:
+					tab.Data[key] = v.Data
+				case *lua.List:
+					tab.Data[key] = v.Data
+				}
 			}
+			// the data of userdata values is already allocated
+			return budget.Consume(len(key))
 		}
-	}
 
-	return nil
+		return budget.Consume(len(key) + lua.SizeOf(tab.Data[key]))
+	}
 }
 
 func tableKeys(c *binder.Context) error {
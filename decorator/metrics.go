@@ -0,0 +1,78 @@
+package decorator
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/krakend/binder"
+	lua "github.com/krakend/krakend-lua/v2"
+)
+
+var errWrongLabels = errors.New("labels expected: a table of strings")
+
+// RegisterMetrics adds the metrics table, recording the counters, gauges and
+// histograms of the scripts in the registry of the config
+func RegisterMetrics(b *binder.Binder) {
+	registry := lua.MetricsRegistryOf(b)
+
+	t := b.Table("metrics")
+	t.Static("counter", recordMetric(registry.Counter))
+	t.Static("gauge", recordMetric(registry.Gauge))
+	t.Static("histogram", recordMetric(registry.Histogram))
+}
+
+// recordMetric accepts the name of the metric, the value (1 by default) and
+// the optional labels
+func recordMetric(record func(string, float64, map[string]string) error) func(*binder.Context) error {
+	return func(c *binder.Context) error {
+		if c.Top() < 1 {
+			return ErrNeedsArguments
+		}
+
+		v := 1.0
+		if c.Top() > 1 {
+			v = c.Arg(2).Number()
+		}
+
+		var labels map[string]string
+		if c.Top() > 2 {
+			var err error
+			if labels, err = metricLabels(c.Arg(3).Any()); err != nil {
+				return err
+			}
+		}
+
+		name := c.Arg(1).String()
+		if strings.HasPrefix(name, lua.ReservedMetricsPrefix) {
+			return lua.ErrReservedMetric
+		}
+		return record(name, v, labels)
+	}
+}
+
+func metricLabels(v interface{}) (map[string]string, error) {
+	res := map[string]string{}
+	switch t := v.(type) {
+	case *lua.NativeTable:
+		var err error
+		t.ForEach(func(key, value lua.NativeValue) {
+			if _, ok := key.(lua.NativeString); !ok {
+				err = errWrongLabels
+				return
+			}
+			res[key.String()] = value.String()
+		})
+		return res, err
+	case *lua.NativeUserData:
+		tab, ok := t.Value.(*lua.Table)
+		if !ok {
+			return nil, errWrongLabels
+		}
+		for k, v := range tab.Data {
+			res[k] = fmt.Sprint(v)
+		}
+		return res, nil
+	}
+	return nil, errWrongLabels
+}
@@ -0,0 +1,72 @@
+package decorator
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/krakend/binder"
+	lua "github.com/krakend/krakend-lua/v2"
+)
+
+func ExampleRegisterMetrics() {
+	bindr := binder.New(binder.Options{
+		SkipOpenLibs:        true,
+		IncludeGoStackTrace: true,
+	})
+
+	RegisterLuaTable(bindr)
+	RegisterMetrics(bindr)
+
+	if err := bindr.DoString(sampleMetricsCode); err != nil {
+		fmt.Println(err.Error())
+	}
+
+	e := &lua.MemoryExporter{}
+	lua.DefaultMetricsRegistry.Export(e)
+
+	m, _ := e.Get("example_requests_total", map[string]string{"tenant": "acme"})
+	fmt.Println(m.Value)
+	m, _ = e.Get("example_requests_total", map[string]string{"tenant": "other"})
+	fmt.Println(m.Value)
+	m, _ = e.Get("example_queue_size", nil)
+	fmt.Println(m.Value)
+	m, _ = e.Get("example_latency_seconds", map[string]string{"backend": "users"})
+	fmt.Println(m.Count, m.Sum)
+
+	// output:
+	// 3
+	// 1
+	// 7
+	// 2 0.75
+}
+
+const sampleMetricsCode = `metrics.counter("example_requests_total", 1, {tenant = "acme"})
+metrics.counter("example_requests_total", 2, {tenant = "acme"})
+metrics.counter("example_requests_total")
+local labels = luaTable.new()
+labels:set("tenant", "other")
+metrics.counter("example_requests_total", 1, labels)
+metrics.gauge("example_queue_size", 7)
+metrics.histogram("example_latency_seconds", 0.25, {backend = "users"})
+metrics.histogram("example_latency_seconds", 0.5, {backend = "users"})
+`
+
+func TestRegisterMetrics_reserved(t *testing.T) {
+	bindr := binder.New(binder.Options{
+		SkipOpenLibs:        true,
+		IncludeGoStackTrace: true,
+	})
+	RegisterMetrics(bindr)
+
+	err := bindr.DoString(`metrics.counter("krakend_lua_errors_total", 1, {endpoint = "/foo"})`)
+	if err == nil || !strings.Contains(err.Error(), lua.ErrReservedMetric.Error()) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	e := &lua.MemoryExporter{}
+	lua.DefaultMetricsRegistry.Export(e)
+	if _, ok := e.Get("krakend_lua_errors_total", map[string]string{"endpoint": "/foo"}); ok {
+		t.Error("the reserved metric has been recorded")
+	}
+}
@@ -0,0 +1,138 @@
+package decorator
+
+import (
+	"errors"
+	"time"
+
+	"github.com/krakend/binder"
+	lua "github.com/krakend/krakend-lua/v2"
+)
+
+var (
+	errUnsupportedCacheValue = errors.New("unsupported value for the shared cache: string, number or boolean expected")
+	errWrongTTL              = errors.New("wrong ttl: seconds or a duration expected")
+)
+
+// RegisterSharedCache adds the shared_cache table, giving access to the cache
+// shared by all the requests handled by the config
+func RegisterSharedCache(b *binder.Binder) {
+	cache := lua.SharedCacheOf(b)
+	budget := lua.DataBudgetOf(b)
+
+	t := b.Table("shared_cache")
+	t.Static("get", cacheGet(cache, budget))
+	t.Static("set", cacheSet(cache))
+	t.Static("delete", cacheDelete(cache))
+	t.Static("incr", cacheIncr(cache))
+}
+
+func cacheGet(cache *lua.SharedCache, budget *lua.DataBudget) func(*binder.Context) error {
+	return func(c *binder.Context) error {
+		if c.Top() != 1 {
+			return ErrNeedsArguments
+		}
+		v, ok := cache.Get(c.Arg(1).String())
+		if !ok {
+			lua.PushNil(c)
+			return nil
+		}
+
+		switch t := v.(type) {
+		case string:
+			if err := budget.Consume(len(t)); err != nil {
+				return err
+			}
+			c.Push().String(t)
+		case float64:
+			c.Push().Number(t)
+		case bool:
+			c.Push().Bool(t)
+		}
+		return nil
+	}
+}
+
+func cacheSet(cache *lua.SharedCache) func(*binder.Context) error {
+	return func(c *binder.Context) error {
+		if c.Top() < 2 {
+			return ErrNeedsArguments
+		}
+
+		var v interface{}
+		switch t := c.Arg(2).Any().(type) {
+		case lua.NativeString:
+			v = string(t)
+		case lua.NativeNumber:
+			v = float64(t)
+		case lua.NativeBool:
+			v = bool(t)
+		default:
+			return errUnsupportedCacheValue
+		}
+
+		ttl, err := cacheTTL(c, 3)
+		if err != nil {
+			return err
+		}
+		cache.Set(c.Arg(1).String(), v, ttl)
+		return nil
+	}
+}
+
+func cacheDelete(cache *lua.SharedCache) func(*binder.Context) error {
+	return func(c *binder.Context) error {
+		if c.Top() != 1 {
+			return ErrNeedsArguments
+		}
+		cache.Delete(c.Arg(1).String())
+		return nil
+	}
+}
+
+// cacheIncr adds the delta (1 by default) to the cached number and pushes the result
+func cacheIncr(cache *lua.SharedCache) func(*binder.Context) error {
+	return func(c *binder.Context) error {
+		if c.Top() < 1 {
+			return ErrNeedsArguments
+		}
+
+		delta := 1.0
+		if c.Top() > 1 {
+			n, ok := c.Arg(2).Any().(lua.NativeNumber)
+			if !ok {
+				return lua.ErrNotANumber
+			}
+			delta = float64(n)
+		}
+
+		ttl, err := cacheTTL(c, 3)
+		if err != nil {
+			return err
+		}
+		n, err := cache.Incr(c.Arg(1).String(), delta, ttl)
+		if err != nil {
+			return err
+		}
+		c.Push().Number(n)
+		return nil
+	}
+}
+
+// cacheTTL parses the optional ttl argument, expressed in seconds or as a duration
+func cacheTTL(c *binder.Context, arg int) (time.Duration, error) {
+	if c.Top() < arg {
+		return 0, nil
+	}
+	switch t := c.Arg(arg).Any().(type) {
+	case lua.NativeNumber:
+		return time.Duration(float64(t) * float64(time.Second)), nil
+	case lua.NativeString:
+		d, err := time.ParseDuration(string(t))
+		if err != nil {
+			return 0, errWrongTTL
+		}
+		return d, nil
+	default:
+		return 0, errWrongTTL
+	}
+}
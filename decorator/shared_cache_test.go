@@ -0,0 +1,46 @@
+package decorator
+
+import (
+	"fmt"
+
+	"github.com/krakend/binder"
+)
+
+func ExampleRegisterSharedCache() {
+	for i := 0; i < 2; i++ {
+		bindr := binder.New(binder.Options{
+			SkipOpenLibs:        true,
+			IncludeGoStackTrace: true,
+		})
+
+		RegisterSharedCache(bindr)
+
+		if err := bindr.DoString(sampleSharedCacheCode); err != nil {
+			fmt.Println(err.Error())
+		}
+		bindr.Close()
+	}
+
+	// output:
+	// true
+	// 1
+	// 3
+	// token
+	// true
+	// 4
+	// 6
+	// token
+	// true
+}
+
+const sampleSharedCacheCode = `if shared_cache.get("token") == nil then
+	print(true)
+	shared_cache.set("token", "token", "1m")
+	shared_cache.set("tmp", 1, 60)
+end
+print(shared_cache.incr("requests"))
+print(shared_cache.incr("requests", 2))
+print(shared_cache.get("token"))
+shared_cache.delete("tmp")
+if shared_cache.get("tmp") == nil then print(true) end
+`
@@ -0,0 +1,84 @@
+package decorator
+
+import (
+	"context"
+	"errors"
+
+	"github.com/krakend/binder"
+	lua "github.com/krakend/krakend-lua/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var errWrongAttributes = errors.New("attributes expected: a table")
+
+// RegisterTrace adds the trace table, giving access to the span of the stage
+// being executed. The ctx function returns the context of that stage
+func RegisterTrace(ctx func() context.Context, b *binder.Binder) {
+	t := b.Table("trace")
+	t.Static("set_attribute", traceSetAttribute(ctx))
+	t.Static("add_event", traceAddEvent(ctx))
+	t.Static("trace_id", traceID(ctx))
+}
+
+func traceSetAttribute(ctx func() context.Context) func(*binder.Context) error {
+	return func(c *binder.Context) error {
+		if c.Top() != 2 {
+			return ErrNeedsArguments
+		}
+		trace.SpanFromContext(ctx()).SetAttributes(spanAttribute(c.Arg(1).String(), c.Arg(2).Any()))
+		return nil
+	}
+}
+
+// traceAddEvent accepts the name of the event and an optional table of attributes
+func traceAddEvent(ctx func() context.Context) func(*binder.Context) error {
+	return func(c *binder.Context) error {
+		if c.Top() < 1 {
+			return ErrNeedsArguments
+		}
+
+		var attrs []attribute.KeyValue
+		if c.Top() > 1 {
+			tab, ok := c.Arg(2).Any().(*lua.NativeTable)
+			if !ok {
+				return errWrongAttributes
+			}
+			tab.ForEach(func(k, v lua.NativeValue) {
+				attrs = append(attrs, spanAttribute(k.String(), v))
+			})
+		}
+
+		trace.SpanFromContext(ctx()).AddEvent(c.Arg(1).String(), trace.WithAttributes(attrs...))
+		return nil
+	}
+}
+
+// traceID pushes the id of the current trace or an empty string
+func traceID(ctx func() context.Context) func(*binder.Context) error {
+	return func(c *binder.Context) error {
+		sc := trace.SpanContextFromContext(ctx())
+		if !sc.HasTraceID() {
+			c.Push().String("")
+			return nil
+		}
+		c.Push().String(sc.TraceID().String())
+		return nil
+	}
+}
+
+func spanAttribute(k string, v interface{}) attribute.KeyValue {
+	switch t := v.(type) {
+	case lua.NativeNumber:
+		f := float64(t)
+		if f == float64(int64(f)) {
+			return attribute.Int64(k, int64(f))
+		}
+		return attribute.Float64(k, f)
+	case lua.NativeBool:
+		return attribute.Bool(k, bool(t))
+	case lua.NativeValue:
+		return attribute.String(k, t.String())
+	}
+	return attribute.String(k, "")
+}
@@ -0,0 +1,130 @@
+package lua
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+)
+
+// egressPolicy restricts the destinations of the http calls made by the
+// scripts. The hosts are checked before sending every request, including the
+// redirects, and the CIDRs are checked against the resolved address before
+// dialing. When the client uses a proxy, the CIDRs apply to the proxy address.
+type egressPolicy struct {
+	allowedHosts []string
+	deniedHosts  []string
+	allowedNets  []*net.IPNet
+	deniedNets   []*net.IPNet
+}
+
+func parseEgressPolicy(c map[string]interface{}) (*egressPolicy, error) {
+	p := &egressPolicy{
+		allowedHosts: stringList(c["allowed_hosts"]),
+		deniedHosts:  stringList(c["denied_hosts"]),
+	}
+
+	var err error
+	if p.allowedNets, err = parseCIDRs(c["allowed_cidrs"]); err != nil {
+		return nil, err
+	}
+	if p.deniedNets, err = parseCIDRs(c["denied_cidrs"]); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func parseCIDRs(v interface{}) ([]*net.IPNet, error) {
+	var res []*net.IPNet
+	for _, cidr := range stringList(v) {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, n)
+	}
+	return res, nil
+}
+
+func stringList(v interface{}) []string {
+	l, _ := v.([]interface{})
+	res := make([]string, 0, len(l))
+	for _, e := range l {
+		if s, ok := e.(string); ok {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+func (p *egressPolicy) checkHost(host string) error {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, pattern := range p.deniedHosts {
+		if matchHost(pattern, host) {
+			return ErrEgressDenied(host)
+		}
+	}
+	if len(p.allowedHosts) == 0 {
+		return nil
+	}
+	for _, pattern := range p.allowedHosts {
+		if matchHost(pattern, host) {
+			return nil
+		}
+	}
+	return ErrEgressDenied(host)
+}
+
+func (p *egressPolicy) checkIP(ip net.IP) error {
+	for _, n := range p.deniedNets {
+		if n.Contains(ip) {
+			return ErrEgressDenied(ip.String())
+		}
+	}
+	if len(p.allowedNets) == 0 {
+		return nil
+	}
+	for _, n := range p.allowedNets {
+		if n.Contains(ip) {
+			return nil
+		}
+	}
+	return ErrEgressDenied(ip.String())
+}
+
+// control is used by the dialer once the address is resolved
+func (p *egressPolicy) control(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ErrEgressDenied(host)
+	}
+	return p.checkIP(ip)
+}
+
+// matchHost supports exact names and wildcards for the subdomains (*.example.com)
+func matchHost(pattern, host string) bool {
+	pattern = strings.ToLower(pattern)
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return strings.HasSuffix(host, "."+suffix)
+	}
+	return pattern == host
+}
+
+type egressTransport struct {
+	policy *egressPolicy
+	next   http.RoundTripper
+}
+
+func (t egressTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if err := t.policy.checkHost(r.URL.Hostname()); err != nil {
+		if r.Body != nil {
+			r.Body.Close()
+		}
+		return nil, err
+	}
+	return t.next.RoundTrip(r)
+}
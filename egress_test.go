@@ -0,0 +1,106 @@
+package lua
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/logging"
+)
+
+func TestParse_egress(t *testing.T) {
+	var redirectTo string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, redirectTo, http.StatusFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	localhostURL := strings.Replace(ts.URL, "127.0.0.1", "localhost", 1)
+	redirectTo = localhostURL
+
+	for _, tc := range []struct {
+		Name          string
+		Egress        map[string]interface{}
+		URL           string
+		ExpectedError error
+	}{
+		{
+			Name:   "no restrictions",
+			Egress: map[string]interface{}{},
+			URL:    ts.URL,
+		},
+		{
+			Name:          "denied cidr",
+			Egress:        map[string]interface{}{"denied_cidrs": []interface{}{"127.0.0.0/8"}},
+			URL:           localhostURL,
+			ExpectedError: ErrEgressDenied("127.0.0.1"),
+		},
+		{
+			Name:          "not allowed cidr",
+			Egress:        map[string]interface{}{"allowed_cidrs": []interface{}{"10.0.0.0/8"}},
+			URL:           ts.URL,
+			ExpectedError: ErrEgressDenied("127.0.0.1"),
+		},
+		{
+			Name:   "allowed cidr",
+			Egress: map[string]interface{}{"allowed_cidrs": []interface{}{"127.0.0.0/8", "::1/128"}},
+			URL:    localhostURL,
+		},
+		{
+			Name:          "denied host",
+			Egress:        map[string]interface{}{"denied_hosts": []interface{}{"localhost"}},
+			URL:           localhostURL,
+			ExpectedError: ErrEgressDenied("localhost"),
+		},
+		{
+			Name:          "not allowed host",
+			Egress:        map[string]interface{}{"allowed_hosts": []interface{}{"*.example.com"}},
+			URL:           ts.URL,
+			ExpectedError: ErrEgressDenied("127.0.0.1"),
+		},
+		{
+			Name:          "denied redirect",
+			Egress:        map[string]interface{}{"allowed_hosts": []interface{}{"127.0.0.1"}},
+			URL:           ts.URL + "/redirect",
+			ExpectedError: ErrEgressDenied("localhost"),
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			cfg, err := Parse(logging.NoOp, config.ExtraConfig{"1234": map[string]interface{}{"egress": tc.Egress}}, "1234")
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+
+			resp, err := cfg.HTTPClient.Get(tc.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+
+			var denied ErrEgressDenied
+			if tc.ExpectedError == nil {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if !errors.As(err, &denied) || denied != tc.ExpectedError {
+				t.Errorf("unexpected error, have: %v, want: %v", err, tc.ExpectedError)
+			}
+		})
+	}
+}
+
+func TestParse_wrongEgress(t *testing.T) {
+	in := config.ExtraConfig{"1234": map[string]interface{}{"egress": map[string]interface{}{"denied_cidrs": []interface{}{"10.0.0.0"}}}}
+	if _, err := Parse(logging.NoOp, in, "1234"); err == nil {
+		t.Error("expecting an error")
+	}
+}
@@ -2,10 +2,13 @@ package lua
 
 import (
 	"fmt"
+	"net/http"
+	"regexp"
 	"strconv"
 	"strings"
 
 	"github.com/krakend/binder"
+	glua "github.com/yuin/gopher-lua"
 )
 
 type ErrWrongChecksumType string
@@ -15,11 +18,47 @@ func (e ErrWrongChecksumType) Error() string {
 }
 
 type ErrWrongChecksum struct {
-	Source, Actual, Expected string
+	Source, Algorithm, Actual, Expected string
 }
 
 func (e ErrWrongChecksum) Error() string {
-	return fmt.Sprintf("lua: wrong cheksum for source %s. have: %v, want: %v", e.Source, e.Actual, e.Expected)
+	return fmt.Sprintf("lua: wrong %s cheksum for source %s. have: %v, want: %v", e.Algorithm, e.Source, e.Actual, e.Expected)
+}
+
+type ErrUnsignedSource string
+
+func (e ErrUnsignedSource) Error() string {
+	return "lua: missing signature for source " + string(e)
+}
+
+type ErrWrongSignature string
+
+func (e ErrWrongSignature) Error() string {
+	return "lua: wrong signature for source " + string(e)
+}
+
+type ErrWrongPublicKey int
+
+func (e ErrWrongPublicKey) Error() string {
+	return fmt.Sprintf("lua: unable to parse the public key #%d", int(e))
+}
+
+type ErrEgressDenied string
+
+func (e ErrEgressDenied) Error() string {
+	return "lua: egress to " + string(e) + " is not allowed"
+}
+
+type ErrUnknownLib string
+
+func (e ErrUnknownLib) Error() string {
+	return "lua: unknown standard library " + string(e)
+}
+
+type ErrSharedCacheConflict string
+
+func (e ErrSharedCacheConflict) Error() string {
+	return "lua: the shared cache " + string(e) + " is already defined with a different max_entries"
 }
 
 type ErrUnknownSource string
@@ -28,6 +67,37 @@ func (e ErrUnknownSource) Error() string {
 	return "lua: unable to load required source " + string(e)
 }
 
+type ErrSyntax struct {
+	Source string
+	Line   int
+	Msg    string
+}
+
+func (e ErrSyntax) Error() string {
+	return fmt.Sprintf("%s (%s:L%d)", e.Msg, e.Source, e.Line)
+}
+
+// ErrScriptLimit is returned when a script exceeds one of the limits defined
+// in the config. It carries the status code the routers should respond with
+type ErrScriptLimit struct {
+	msg  string
+	code int
+}
+
+func (e ErrScriptLimit) StatusCode() int {
+	return e.code
+}
+
+func (e ErrScriptLimit) Error() string {
+	return e.msg
+}
+
+var (
+	ErrScriptTimeout        = ErrScriptLimit{msg: "lua: script execution timed out", code: http.StatusGatewayTimeout}
+	ErrScriptBudgetExceeded = ErrScriptLimit{msg: "lua: script instruction budget exceeded", code: http.StatusInternalServerError}
+	ErrMemoryLimit          = ErrScriptLimit{msg: "lua: memory limit exceeded", code: http.StatusInternalServerError}
+)
+
 type ErrInternal string
 
 func (e ErrInternal) Error() string {
@@ -61,6 +131,10 @@ func ToError(e error, source *SourceMap) error {
 		return nil
 	}
 
+	if apiError, ok := e.(*glua.ApiError); ok {
+		return apiErrorToError(apiError, source)
+	}
+
 	binderError, ok := e.(*binder.Error)
 	if !ok {
 		return e
@@ -68,7 +142,7 @@ func ToError(e error, source *SourceMap) error {
 
 	originalMsg := binderError.Error()
 	msgSplitIndex := strings.Index(originalMsg, ":")
-	errMsgParts := strings.Split(originalMsg[msgSplitIndex+2:], " || ")
+	errMsgParts := strings.Split(originalMsg[msgSplitIndex+2:], separator)
 
 	if len(errMsgParts) == 0 {
 		return binderError
@@ -90,6 +164,12 @@ func ToError(e error, source *SourceMap) error {
 	}
 
 	// If the error was correctly splitted by separator means we're dealing with a LUA custom_error()
+	return customError(errMsgParts)
+}
+
+const separator = " || "
+
+func customError(errMsgParts []string) error {
 	code, err := strconv.Atoi(errMsgParts[1])
 	if err != nil {
 		code = 500
@@ -109,3 +189,31 @@ func ToError(e error, source *SourceMap) error {
 		contentType:     errMsgParts[2],
 	}
 }
+
+var luaErrorPattern = regexp.MustCompile(`(?s)^(.+?):(\d+): (.*)$`)
+
+// apiErrorToError converts the errors returned by the execution of compiled chunks.
+// Their messages look like "chunk:line: message", where the chunk is the name of
+// the script or the sources block, so the affected file can be resolved with the
+// source map
+func apiErrorToError(e *glua.ApiError, source *SourceMap) error {
+	msg := e.Object.String()
+	location := ""
+	if parts := luaErrorPattern.FindStringSubmatch(msg); parts != nil {
+		msg = parts[3]
+		line, _ := strconv.Atoi(parts[2])
+		location = fmt.Sprintf(" (%s:L%d)", parts[1], line)
+		if parts[1] == sourcesChunk && source != nil {
+			if affectedScript, relativeLine, err := source.AffectedSource(line); err == nil {
+				location = fmt.Sprintf(" (%s:L%d)", affectedScript, relativeLine)
+			}
+		}
+	}
+
+	errMsgParts := strings.Split(msg, separator)
+	if len(errMsgParts) == 1 {
+		return ErrInternal(msg + location)
+	}
+
+	return customError(errMsgParts)
+}
@@ -0,0 +1,148 @@
+package lua
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/krakend/binder"
+)
+
+// DefaultMaxRedirects is the number of redirects followed by the http client
+// when the config does not define it
+const DefaultMaxRedirects = 10
+
+// DefaultBatchConcurrency is the max number of calls of a batch executed at the
+// same time when the config does not define it
+const DefaultBatchConcurrency = 8
+
+// HTTPClientOf returns the http client to use by the decorators of the binder:
+// the one defined in the http_client block of its config or the default one
+func HTTPClientOf(b *binder.Binder) *http.Client {
+	if vm := vmOf(b); vm != nil && vm.client != nil {
+		return vm.client
+	}
+	return http.DefaultClient
+}
+
+// BatchConcurrencyOf returns the max number of concurrent calls of the batches
+// executed by the decorators of the binder
+func BatchConcurrencyOf(b *binder.Binder) int {
+	if vm := vmOf(b); vm != nil && vm.batchMax > 0 {
+		return vm.batchMax
+	}
+	return DefaultBatchConcurrency
+}
+
+// newHTTPClient returns a dedicated client with the options of the http_client
+// block of the config, restricted by the egress block if present
+func newHTTPClient(c, egress map[string]interface{}) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	client := &http.Client{Transport: transport}
+
+	if egress != nil {
+		policy, err := parseEgressPolicy(egress)
+		if err != nil {
+			return nil, fmt.Errorf("lua: wrong egress config: %w", err)
+		}
+		transport.DialContext = (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+			Control:   policy.control,
+		}).DialContext
+		client.Transport = egressTransport{policy: policy, next: transport}
+	}
+
+	if v, ok := c["timeout"].(string); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, httpClientError(err)
+		}
+		client.Timeout = d
+	}
+	if v, ok := c["max_idle_conns"].(float64); ok && v >= 0 {
+		transport.MaxIdleConns = int(v)
+	}
+	if v, ok := c["max_idle_conns_per_host"].(float64); ok && v >= 0 {
+		transport.MaxIdleConnsPerHost = int(v)
+	}
+	if v, ok := c["proxy_url"].(string); ok && v != "" {
+		u, err := url.Parse(v)
+		if err != nil {
+			return nil, httpClientError(err)
+		}
+		transport.Proxy = http.ProxyURL(u)
+	}
+
+	tlsConfig, err := newTLSConfig(c)
+	if err != nil {
+		return nil, httpClientError(err)
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	maxRedirects := DefaultMaxRedirects
+	if v, ok := c["max_redirects"].(float64); ok && v >= 0 {
+		maxRedirects = int(v)
+	}
+	if b, ok := c["follow_redirects"].(bool); ok && !b {
+		maxRedirects = 0
+	}
+	client.CheckRedirect = func(_ *http.Request, via []*http.Request) error {
+		if len(via) > maxRedirects {
+			return http.ErrUseLastResponse
+		}
+		return nil
+	}
+
+	return client, nil
+}
+
+func newTLSConfig(c map[string]interface{}) (*tls.Config, error) {
+	res := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if b, ok := c["insecure_skip_verify"].(bool); ok {
+		res.InsecureSkipVerify = b // skipcq: GSC-G402
+	}
+
+	if certs, ok := c["ca_certs"].([]interface{}); ok && len(certs) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		for _, v := range certs {
+			path, _ := v.(string)
+			pem, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in %s", path)
+			}
+		}
+		res.RootCAs = pool
+	}
+
+	if certs, ok := c["client_certs"].([]interface{}); ok {
+		for _, v := range certs {
+			cert, _ := v.(map[string]interface{})
+			certFile, _ := cert["certificate"].(string)
+			keyFile, _ := cert["private_key"].(string)
+			pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+			if err != nil {
+				return nil, err
+			}
+			res.Certificates = append(res.Certificates, pair)
+		}
+	}
+
+	return res, nil
+}
+
+func httpClientError(err error) error {
+	return fmt.Errorf("lua: wrong http_client config: %w", err)
+}
@@ -0,0 +1,151 @@
+package lua
+
+import (
+	"context"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/logging"
+)
+
+func TestParse_httpClient(t *testing.T) {
+	tlsServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer tlsServer.Close()
+
+	var redirects atomic.Int32
+	redirectServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		redirects.Add(1)
+		http.Redirect(w, r, "/next", http.StatusFound)
+	}))
+	defer redirectServer.Close()
+
+	slowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer slowServer.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tlsServer.Certificate().Raw})
+	if err := os.WriteFile(caFile, ca, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		Name           string
+		Cfg            map[string]interface{}
+		URL            string
+		ExpectedStatus int
+		ExpectedError  bool
+		Redirects      int
+	}{
+		{
+			Name:          "unknown CA",
+			Cfg:           map[string]interface{}{},
+			URL:           tlsServer.URL,
+			ExpectedError: true,
+		},
+		{
+			Name:           "CA bundle",
+			Cfg:            map[string]interface{}{"ca_certs": []interface{}{caFile}},
+			URL:            tlsServer.URL,
+			ExpectedStatus: http.StatusOK,
+		},
+		{
+			Name:           "insecure",
+			Cfg:            map[string]interface{}{"insecure_skip_verify": true},
+			URL:            tlsServer.URL,
+			ExpectedStatus: http.StatusOK,
+		},
+		{
+			Name:          "timeout",
+			Cfg:           map[string]interface{}{"timeout": "10ms"},
+			URL:           slowServer.URL,
+			ExpectedError: true,
+		},
+		{
+			Name:           "no redirects",
+			Cfg:            map[string]interface{}{"follow_redirects": false},
+			URL:            redirectServer.URL,
+			ExpectedStatus: http.StatusFound,
+			Redirects:      1,
+		},
+		{
+			Name:           "max redirects",
+			Cfg:            map[string]interface{}{"max_redirects": 2.0},
+			URL:            redirectServer.URL,
+			ExpectedStatus: http.StatusFound,
+			Redirects:      3,
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			redirects.Store(0)
+			cfg, err := Parse(logging.NoOp, config.ExtraConfig{"1234": map[string]interface{}{"http_client": tc.Cfg}}, "1234")
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+
+			p := NewPool(&cfg, func(*VM) {})
+			vm, err := p.Get(context.Background())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer p.Put(vm)
+
+			client := HTTPClientOf(vm.GetBinder())
+			if client != cfg.HTTPClient || client == http.DefaultClient {
+				t.Error("the VM is not using the client of the config")
+				return
+			}
+
+			resp, err := client.Get(tc.URL)
+			if tc.ExpectedError {
+				if err == nil {
+					t.Error("expecting an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tc.ExpectedStatus {
+				t.Errorf("unexpected status code: %d", resp.StatusCode)
+			}
+			if tc.Redirects > 0 && int(redirects.Load()) != tc.Redirects {
+				t.Errorf("unexpected number of redirects: %d", redirects.Load())
+			}
+		})
+	}
+}
+
+func TestParse_wrongHTTPClient(t *testing.T) {
+	for _, cfg := range []map[string]interface{}{
+		{"timeout": "soon"},
+		{"ca_certs": []interface{}{"unknown.pem"}},
+		{"client_certs": []interface{}{map[string]interface{}{"certificate": "unknown.pem", "private_key": "unknown.key"}}},
+		{"proxy_url": "://proxy"},
+	} {
+		_, err := Parse(logging.NoOp, config.ExtraConfig{"1234": map[string]interface{}{"http_client": cfg}}, "1234")
+		if err == nil {
+			t.Errorf("expecting an error for %v", cfg)
+		}
+	}
+
+	if HTTPClientOf(nil) != http.DefaultClient {
+		t.Error("unexpected client for an unknown binder")
+	}
+}
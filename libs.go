@@ -0,0 +1,70 @@
+package lua
+
+import (
+	"strings"
+
+	glua "github.com/yuin/gopher-lua"
+)
+
+// DefaultDeniedFunctions are the functions removed from the states unless the
+// config allows to open all the standard libraries. The base library is always
+// open, so its functions loading code from strings and files are denied too
+var DefaultDeniedFunctions = []string{"os.execute", "io.popen", "load", "loadstring", "loadfile", "dofile"}
+
+// standardLibs are the libraries that can be listed in the open_libs option.
+// The package, base and table libraries are always open.
+var standardLibs = map[string]glua.LGFunction{
+	glua.LoadLibName:      glua.OpenPackage,
+	glua.BaseLibName:      glua.OpenBase,
+	glua.TabLibName:       glua.OpenTable,
+	glua.IoLibName:        glua.OpenIo,
+	glua.OsLibName:        glua.OpenOs,
+	glua.StringLibName:    glua.OpenString,
+	glua.MathLibName:      glua.OpenMath,
+	glua.DebugLibName:     glua.OpenDebug,
+	glua.ChannelLibName:   glua.OpenChannel,
+	glua.CoroutineLibName: glua.OpenCoroutine,
+}
+
+func openLibs(L *glua.LState, libs []string) {
+	for _, name := range libs {
+		L.Push(L.NewFunction(standardLibs[name]))
+		L.Push(glua.LString(name))
+		L.Call(1, 0)
+	}
+}
+
+// denyFunctions removes the functions from the state. The names of the
+// functions of a library are prefixed by the library name (os.execute).
+func denyFunctions(L *glua.LState, names []string) {
+	for _, name := range names {
+		lib, fn, ok := strings.Cut(name, ".")
+		if !ok {
+			L.SetGlobal(name, glua.LNil)
+			continue
+		}
+		if t, ok := L.GetGlobal(lib).(*glua.LTable); ok {
+			t.RawSetString(fn, glua.LNil)
+		}
+	}
+}
+
+// closeFileLoaders removes the loader of the package library searching the
+// package.path, so require can not execute the lua files of the disk. The
+// preloaded modules and the ones of the module paths are still available
+func closeFileLoaders(L *glua.LState) {
+	pkg, ok := L.GetGlobal(glua.LoadLibName).(*glua.LTable)
+	if !ok {
+		return
+	}
+	pkg.RawSetString("path", glua.LString(""))
+	pkg.RawSetString("cpath", glua.LString(""))
+
+	// the first loader is the one of package.preload. The table is shared
+	// with the registry, so require stops using the removed loaders too
+	if loaders, ok := pkg.RawGetString("loaders").(*glua.LTable); ok {
+		for i := loaders.Len(); i > 1; i-- {
+			loaders.Remove(i)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package lua
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/logging"
+	glua "github.com/yuin/gopher-lua"
+)
+
+func TestPool_openLibs(t *testing.T) {
+	for _, tc := range []struct {
+		Name          string
+		Cfg           map[string]interface{}
+		Pre           string
+		ExpectedError string
+	}{
+		{
+			Name: "string lib",
+			Cfg:  map[string]interface{}{"open_libs": []interface{}{"string", "math"}},
+			Pre:  "if string.upper('a') ~= 'A' or math.floor(1.5) ~= 1 then error('wrong result') end\nif os ~= nil or io ~= nil then error('os and io are open') end",
+		},
+		{
+			Name: "default denylist",
+			Cfg:  map[string]interface{}{"open_libs": []interface{}{"os", "io"}},
+			Pre:  "if os.time() == nil then error('os.time is not available') end\nif os.execute ~= nil or io.popen ~= nil or load ~= nil or dofile ~= nil then error('denied function available') end",
+		},
+		{
+			Name: "default denylist loaders",
+			Cfg:  map[string]interface{}{"open_libs": []interface{}{"string"}},
+			Pre:  "if loadstring ~= nil or loadfile ~= nil then error('code loaders available') end",
+		},
+		{
+			Name: "default denylist without libs",
+			Cfg:  map[string]interface{}{},
+			Pre:  "if load ~= nil or loadstring ~= nil or loadfile ~= nil or dofile ~= nil then error('code loaders available') end",
+		},
+		{
+			Name:          "denied function",
+			Cfg:           map[string]interface{}{"open_libs": []interface{}{"os"}},
+			Pre:           "os.execute('true')",
+			ExpectedError: "attempt to call a non-function object",
+		},
+		{
+			Name: "custom denylist",
+			Cfg: map[string]interface{}{
+				"allow_open_libs":  true,
+				"denied_functions": []interface{}{"os.exit", "loadstring"},
+			},
+			Pre: "if os.exit ~= nil or loadstring ~= nil then error('denied function available') end\nif os.execute == nil then error('os.execute is not available') end",
+		},
+		{
+			Name: "all libs",
+			Cfg:  map[string]interface{}{"allow_open_libs": true},
+			Pre:  "if os.execute == nil or load == nil then error('missing functions') end",
+		},
+		{
+			Name: "no libs",
+			Cfg:  map[string]interface{}{},
+			Pre:  "if string ~= nil or os ~= nil then error('libs are open') end",
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			tc.Cfg["pre"] = tc.Pre
+			cfg, err := Parse(logging.NoOp, config.ExtraConfig{"1234": tc.Cfg}, "1234")
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+
+			p := NewPool(&cfg, func(*VM) {})
+			vm, err := p.Get(context.Background())
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			defer p.Put(vm)
+
+			err = vm.Pre()
+			if tc.ExpectedError == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.ExpectedError) {
+				t.Errorf("unexpected error, have: %v, want: %s", err, tc.ExpectedError)
+			}
+		})
+	}
+}
+
+func TestPool_requireFiles(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "secret.lua"), []byte("leaked = true"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	pre := fmt.Sprintf("package.path = %q\nrequire('secret')", filepath.Join(dir, "?.lua"))
+
+	for _, tc := range []struct {
+		Name string
+		Cfg  map[string]interface{}
+	}{
+		{Name: "open libs", Cfg: map[string]interface{}{"open_libs": []interface{}{"string"}}},
+		{Name: "default libs", Cfg: map[string]interface{}{}},
+		{Name: "package lib", Cfg: map[string]interface{}{"open_libs": []interface{}{"package"}}},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			tc.Cfg["pre"] = pre
+			cfg, err := Parse(logging.NoOp, config.ExtraConfig{"1234": tc.Cfg}, "1234")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			p := NewPool(&cfg, func(*VM) {})
+			vm, err := p.Get(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Put(vm)
+
+			if err := vm.Pre(); err == nil || !strings.Contains(err.Error(), "module secret not found") {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if vm.state.GetGlobal("leaked") != glua.LNil {
+				t.Error("the file has been executed")
+			}
+		})
+	}
+}
+
+func TestParse_unknownLib(t *testing.T) {
+	in := config.ExtraConfig{
+		"1234": map[string]interface{}{
+			"open_libs": []interface{}{"string", "net"},
+		},
+	}
+	if _, err := Parse(logging.NoOp, in, "1234"); err != ErrUnknownLib("net") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
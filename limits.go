@@ -0,0 +1,65 @@
+package lua
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Limits bounds the execution of every script run by a VM
+type Limits struct {
+	// Timeout is the max duration of a script execution. 0 means no timeout
+	Timeout time.Duration
+	// MaxInstructions is the max number of lua instructions a script execution
+	// can run. 0 means no limit
+	MaxInstructions int64
+	// RegistrySize is the size of the data stack of the lua state. 0 means the
+	// default size
+	RegistrySize int
+	// CallStackSize is the size of the call stack of the lua state. 0 means the
+	// default size
+	CallStackSize int
+	// MaxDataSize is the max number of bytes a request can store in luaTable and
+	// luaList instances or read from bodies. 0 means no limit
+	MaxDataSize int
+}
+
+func (l Limits) bounded() bool {
+	return l.Timeout > 0 || l.MaxInstructions > 0
+}
+
+// scriptContext is the context bound to the lua state during the execution of
+// a script. The lua VM checks it before running every instruction, so it is also
+// used to account the instruction budget
+type scriptContext struct {
+	context.Context
+	budget int64
+	used   int64
+}
+
+var closedChan = func() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}()
+
+var errBudgetExhausted = errors.New("instruction budget exhausted")
+
+func (c *scriptContext) Done() <-chan struct{} {
+	c.used++
+	if c.exhausted() {
+		return closedChan
+	}
+	return c.Context.Done()
+}
+
+func (c *scriptContext) Err() error {
+	if c.exhausted() {
+		return errBudgetExhausted
+	}
+	return c.Context.Err()
+}
+
+func (c *scriptContext) exhausted() bool {
+	return c.budget > 0 && c.used > c.budget
+}
@@ -0,0 +1,74 @@
+package lua
+
+import (
+	"encoding/json"
+
+	"github.com/krakend/binder"
+)
+
+// DataBudget accounts the bytes stored by a script into luaTable and luaList
+// instances and the bodies it reads, so a VM can not hold more than the max
+// data size defined in the config during a request
+type DataBudget struct {
+	max  int
+	used int
+}
+
+// Consume adds n bytes to the budget and returns ErrMemoryLimit if the max size
+// has been exceeded. Nil budgets do not have limits.
+func (d *DataBudget) Consume(n int) error {
+	if d == nil || d.max <= 0 {
+		return nil
+	}
+	d.used += n
+	if d.used > d.max {
+		return ErrMemoryLimit
+	}
+	return nil
+}
+
+func (d *DataBudget) exceeded() bool {
+	return d != nil && d.max > 0 && d.used > d.max
+}
+
+func (d *DataBudget) reset() {
+	if d != nil {
+		d.used = 0
+	}
+}
+
+// DataBudgetOf returns the data budget of the VM owning the binder. It returns
+// nil for binders not created by a pool or configs without a max data size.
+func DataBudgetOf(b *binder.Binder) *DataBudget {
+	if vm := vmOf(b); vm != nil {
+		return vm.budget
+	}
+	return nil
+}
+
+// SizeOf returns an estimation of the bytes required to store the value
+func SizeOf(v interface{}) int {
+	switch t := v.(type) {
+	case string:
+		return len(t)
+	case json.Number:
+		return len(t)
+	case bool:
+		return 1
+	case int, float64:
+		return 8
+	case []interface{}:
+		size := 0
+		for _, e := range t {
+			size += SizeOf(e) + 8
+		}
+		return size
+	case map[string]interface{}:
+		size := 0
+		for k, e := range t {
+			size += len(k) + SizeOf(e)
+		}
+		return size
+	}
+	return 0
+}
@@ -0,0 +1,265 @@
+package lua
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/krakend/binder"
+)
+
+// DefaultMaxSeries is the max number of label combinations a registry keeps
+// for every metric, so the scripts can not exhaust the memory
+const DefaultMaxSeries = 1000
+
+// DefaultHistogramBuckets are the upper bounds of the buckets of the histograms
+var DefaultHistogramBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// ReservedMetricsPrefix is the prefix of the execution metrics. The scripts
+// can not record metrics starting with it
+const ReservedMetricsPrefix = "krakend_lua_"
+
+// DefaultMetricsRegistry collects the metrics of the configs not defining their own registry
+var DefaultMetricsRegistry = NewMetricsRegistry()
+
+var (
+	ErrTooManySeries   = errors.New("lua: too many label combinations for the metric")
+	ErrReservedMetric  = errors.New("lua: the " + ReservedMetricsPrefix + " prefix is reserved")
+	metricNameRegexp   = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	metricLabelRegexp  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	errNegativeCounter = errors.New("lua: counters can not decrease")
+)
+
+type ErrWrongMetric string
+
+func (e ErrWrongMetric) Error() string {
+	return "lua: wrong metric " + string(e)
+}
+
+type MetricType string
+
+const (
+	CounterMetric   MetricType = "counter"
+	GaugeMetric     MetricType = "gauge"
+	HistogramMetric MetricType = "histogram"
+)
+
+// Metric is the state of a metric for a combination of labels. Counters and
+// gauges use the Value, while histograms use the Count, the Sum and the
+// cumulative Buckets
+type Metric struct {
+	Name    string
+	Type    MetricType
+	Labels  map[string]string
+	Value   float64
+	Count   uint64
+	Sum     float64
+	Buckets []Bucket
+}
+
+// Bucket is the number of observations less or equal than the upper bound
+type Bucket struct {
+	UpperBound float64
+	Count      uint64
+}
+
+// MetricsExporter publishes the metrics collected by a registry
+type MetricsExporter interface {
+	Export([]Metric) error
+}
+
+// MetricsRegistry collects the metrics emitted by the scripts. It is safe for
+// concurrent use
+type MetricsRegistry struct {
+	mu        sync.Mutex
+	maxSeries int
+	families  map[string]*metricFamily
+}
+
+type metricFamily struct {
+	typ    MetricType
+	series map[string]*Metric
+}
+
+// NewMetricsRegistry returns an empty registry
+func NewMetricsRegistry() *MetricsRegistry {
+	return newMetricsRegistry(DefaultMaxSeries)
+}
+
+// newMetricsRegistry returns an empty registry keeping up to maxSeries label
+// combinations for every metric. It does not limit them if maxSeries is not
+// positive
+func newMetricsRegistry(maxSeries int) *MetricsRegistry {
+	return &MetricsRegistry{
+		maxSeries: maxSeries,
+		families:  map[string]*metricFamily{},
+	}
+}
+
+// MetricsRegistryOf returns the registry of the VM owning the binder or the
+// default one
+func MetricsRegistryOf(b *binder.Binder) *MetricsRegistry {
+	if vm := vmOf(b); vm != nil && vm.metrics != nil {
+		return vm.metrics
+	}
+	return DefaultMetricsRegistry
+}
+
+// Counter adds the value to the counter
+func (r *MetricsRegistry) Counter(name string, v float64, labels map[string]string) error {
+	if v < 0 {
+		return errNegativeCounter
+	}
+	return r.update(name, CounterMetric, labels, func(m *Metric) { m.Value += v })
+}
+
+// Gauge sets the value of the gauge
+func (r *MetricsRegistry) Gauge(name string, v float64, labels map[string]string) error {
+	return r.update(name, GaugeMetric, labels, func(m *Metric) { m.Value = v })
+}
+
+// Histogram records an observation
+func (r *MetricsRegistry) Histogram(name string, v float64, labels map[string]string) error {
+	return r.update(name, HistogramMetric, labels, func(m *Metric) {
+		if m.Buckets == nil {
+			m.Buckets = make([]Bucket, len(DefaultHistogramBuckets))
+			for i, b := range DefaultHistogramBuckets {
+				m.Buckets[i].UpperBound = b
+			}
+		}
+		m.Count++
+		m.Sum += v
+		for i := range m.Buckets {
+			if v <= m.Buckets[i].UpperBound {
+				m.Buckets[i].Count++
+			}
+		}
+	})
+}
+
+// Snapshot returns a copy of the metrics, sorted by name and labels
+func (r *MetricsRegistry) Snapshot() []Metric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	res := []Metric{}
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := r.families[name]
+		keys := make([]string, 0, len(f.series))
+		for k := range f.series {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			m := *f.series[k]
+			m.Labels = make(map[string]string, len(f.series[k].Labels))
+			for l, v := range f.series[k].Labels {
+				m.Labels[l] = v
+			}
+			m.Buckets = append([]Bucket(nil), m.Buckets...)
+			res = append(res, m)
+		}
+	}
+	return res
+}
+
+// Export sends a snapshot of the metrics to the exporter
+func (r *MetricsRegistry) Export(e MetricsExporter) error {
+	return e.Export(r.Snapshot())
+}
+
+func (r *MetricsRegistry) update(name string, typ MetricType, labels map[string]string, f func(*Metric)) error {
+	if !metricNameRegexp.MatchString(name) {
+		return ErrWrongMetric(fmt.Sprintf("name %q", name))
+	}
+	for l := range labels {
+		if !metricLabelRegexp.MatchString(l) || strings.HasPrefix(l, "__") || (typ == HistogramMetric && l == "le") {
+			return ErrWrongMetric(fmt.Sprintf("label %q", l))
+		}
+	}
+	key := labelsKey(labels)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	family, ok := r.families[name]
+	if !ok {
+		family = &metricFamily{typ: typ, series: map[string]*Metric{}}
+		r.families[name] = family
+	}
+	if family.typ != typ {
+		return ErrWrongMetric(fmt.Sprintf("type for %s: %s, it is a %s", name, typ, family.typ))
+	}
+
+	m, ok := family.series[key]
+	if !ok {
+		if r.maxSeries > 0 && len(family.series) >= r.maxSeries {
+			return ErrTooManySeries
+		}
+		m = &Metric{Name: name, Type: typ, Labels: make(map[string]string, len(labels))}
+		for l, v := range labels {
+			m.Labels[l] = v
+		}
+		family.series[key] = m
+	}
+	f(m)
+	return nil
+}
+
+func labelsKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for l := range labels {
+		names = append(names, l)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	for _, l := range names {
+		sb.WriteString(l)
+		sb.WriteByte(0xff)
+		sb.WriteString(labels[l])
+		sb.WriteByte(0xfe)
+	}
+	return sb.String()
+}
+
+// MemoryExporter keeps the last exported metrics. It is a stand-in for the
+// real exporters in tests
+type MemoryExporter struct {
+	mu      sync.Mutex
+	metrics []Metric
+}
+
+func (e *MemoryExporter) Export(metrics []Metric) error {
+	e.mu.Lock()
+	e.metrics = metrics
+	e.mu.Unlock()
+	return nil
+}
+
+// Metrics returns the last exported metrics
+func (e *MemoryExporter) Metrics() []Metric {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.metrics
+}
+
+// Get returns the last exported metric with the name and the labels
+func (e *MemoryExporter) Get(name string, labels map[string]string) (Metric, bool) {
+	key := labelsKey(labels)
+	for _, m := range e.Metrics() {
+		if m.Name == name && labelsKey(m.Labels) == key {
+			return m, true
+		}
+	}
+	return Metric{}, false
+}
@@ -0,0 +1,113 @@
+package lua
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestMetricsRegistry(t *testing.T) {
+	r := NewMetricsRegistry()
+
+	for _, tenant := range []string{"a", "b", "a"} {
+		if err := r.Counter("requests_total", 1, map[string]string{"tenant": tenant}); err != nil {
+			t.Error(err)
+		}
+	}
+	if err := r.Gauge("queue_size", 3, nil); err != nil {
+		t.Error(err)
+	}
+	if err := r.Gauge("queue_size", 2, nil); err != nil {
+		t.Error(err)
+	}
+	for _, v := range []float64{0.003, 0.2, 20} {
+		if err := r.Histogram("latency_seconds", v, map[string]string{"path": `/"a"`}); err != nil {
+			t.Error(err)
+		}
+	}
+
+	for _, err := range []error{
+		r.Gauge("requests_total", 1, map[string]string{"tenant": "a"}),
+		r.Counter("wrong-name", 1, nil),
+		r.Counter("requests_total", 1, map[string]string{"wrong-label": "a"}),
+		r.Histogram("latency_seconds", 1, map[string]string{"le": "1"}),
+		r.Counter("requests_total", -1, nil),
+	} {
+		if err == nil {
+			t.Error("error expected")
+		}
+	}
+
+	e := &MemoryExporter{}
+	if err := r.Export(e); err != nil {
+		t.Error(err)
+	}
+	if m, ok := e.Get("requests_total", map[string]string{"tenant": "a"}); !ok || m.Value != 2 {
+		t.Errorf("unexpected counter: %+v", m)
+	}
+	if m, ok := e.Get("queue_size", nil); !ok || m.Value != 2 {
+		t.Errorf("unexpected gauge: %+v", m)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := r.Export(PrometheusExporter{W: buf}); err != nil {
+		t.Error(err)
+	}
+	expected := `# TYPE latency_seconds histogram
+latency_seconds_bucket{path="/\"a\"",le="0.005"} 1
+latency_seconds_bucket{path="/\"a\"",le="0.01"} 1
+latency_seconds_bucket{path="/\"a\"",le="0.025"} 1
+latency_seconds_bucket{path="/\"a\"",le="0.05"} 1
+latency_seconds_bucket{path="/\"a\"",le="0.1"} 1
+latency_seconds_bucket{path="/\"a\"",le="0.25"} 2
+latency_seconds_bucket{path="/\"a\"",le="0.5"} 2
+latency_seconds_bucket{path="/\"a\"",le="1"} 2
+latency_seconds_bucket{path="/\"a\"",le="2.5"} 2
+latency_seconds_bucket{path="/\"a\"",le="5"} 2
+latency_seconds_bucket{path="/\"a\"",le="10"} 2
+latency_seconds_bucket{path="/\"a\"",le="+Inf"} 3
+latency_seconds_sum{path="/\"a\""} 20.203
+latency_seconds_count{path="/\"a\""} 3
+# TYPE queue_size gauge
+queue_size 2
+# TYPE requests_total counter
+requests_total{tenant="a"} 2
+requests_total{tenant="b"} 1
+`
+	if buf.String() != expected {
+		t.Errorf("unexpected output:\n%s", buf.String())
+	}
+}
+
+func TestMetricsRegistry_maxSeries(t *testing.T) {
+	r := NewMetricsRegistry()
+	r.maxSeries = 2
+
+	for _, id := range []string{"a", "b"} {
+		if err := r.Counter("requests_total", 1, map[string]string{"id": id}); err != nil {
+			t.Error(err)
+		}
+	}
+	if err := r.Counter("requests_total", 1, map[string]string{"id": "c"}); err != ErrTooManySeries {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := r.Counter("requests_total", 1, map[string]string{"id": "a"}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPool_metrics(t *testing.T) {
+	registry := NewMetricsRegistry()
+	cfg := &Config{SourceLoader: onceLoader{}, Metrics: registry}
+
+	p := NewPool(cfg, func(vm *VM) {
+		if MetricsRegistryOf(vm.GetBinder()) != registry {
+			t.Error("unexpected registry")
+		}
+	})
+	vm, err := p.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Put(vm)
+}
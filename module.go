@@ -0,0 +1,97 @@
+package lua
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/luraproject/lura/v2/logging"
+	glua "github.com/yuin/gopher-lua"
+)
+
+type luaModule struct {
+	name string
+	file string
+}
+
+// findModules returns the lua files found in the module paths. The name of a
+// module is its path relative to the module path, with dots as separators,
+// so the file lib/json/init.lua is the module json of the path lib. If several
+// paths contain the same module, the first one takes precedence.
+func findModules(l logging.Logger, paths []string) []luaModule {
+	var res []luaModule
+	seen := map[string]struct{}{}
+
+	for _, path := range paths {
+		err := filepath.WalkDir(path, func(file string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || filepath.Ext(file) != ".lua" {
+				return nil
+			}
+			rel, err := filepath.Rel(path, file)
+			if err != nil {
+				return err
+			}
+			rel = strings.TrimSuffix(filepath.ToSlash(rel), ".lua")
+			if rel == "init" {
+				return nil
+			}
+			rel = strings.TrimSuffix(rel, "/init")
+
+			name := strings.ReplaceAll(rel, "/", ".")
+			if _, ok := seen[name]; ok {
+				return nil
+			}
+			seen[name] = struct{}{}
+			res = append(res, luaModule{name: name, file: file})
+			return nil
+		})
+		if err != nil {
+			l.Error("[Lua] Loading the modules:", err.Error())
+		}
+	}
+
+	return res
+}
+
+// require loads the module with the given name from the compiled program. The
+// values returned by the modules are cached, so each module is executed once
+// per VM.
+func (vm *VM) require(L *glua.LState) int {
+	name := L.CheckString(1)
+	if v, ok := vm.modules[name]; ok {
+		if v == nil {
+			L.RaiseError("loop or previous error loading module '%s'", name)
+		}
+		L.Push(v)
+		return 1
+	}
+
+	proto, ok := vm.program.Modules[name]
+	if !ok {
+		L.RaiseError("module '%s' not found", name)
+	}
+
+	// the nil entry detects the loops, and it is removed if the module fails
+	vm.modules[name] = nil
+	defer func() {
+		if vm.modules[name] == nil {
+			delete(vm.modules, name)
+		}
+	}()
+
+	L.Push(L.NewFunctionFromProto(proto))
+	L.Push(glua.LString(name))
+	L.Call(1, 1)
+
+	v := L.Get(-1)
+	if v == glua.LNil {
+		v = glua.LTrue
+		L.Pop(1)
+		L.Push(v)
+	}
+	vm.modules[name] = v
+	return 1
+}
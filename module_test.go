@@ -0,0 +1,118 @@
+package lua
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/logging"
+)
+
+func TestPool_require(t *testing.T) {
+	dir := t.TempDir()
+	for file, content := range map[string]string{
+		"counter.lua":        "loads = (loads or 0) + 1\nlocal M = {}\nfunction M.loads() return loads end\nreturn M",
+		"util/strings.lua":   "local M = {}\nfunction M.shout(s) return s .. '!' end\nreturn M",
+		"util/init.lua":      "return { name = 'util' }",
+		"broken/runtime.lua": "local a = nil\nreturn a.b",
+		"notes.txt":          "not a module",
+	} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, file)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		Name          string
+		Sources       string
+		Pre           string
+		ExpectedError string
+	}{
+		{
+			Name:    "cached per VM",
+			Sources: "local c = require('counter')\nif c ~= require('counter') then error('not cached') end",
+			Pre:     "local c = require('counter')\nif c.loads() ~= 1 then error('loaded ' .. c.loads() .. ' times') end",
+		},
+		{
+			Name: "nested modules",
+			Pre:  "if require('util.strings').shout('a') ~= 'a!' then error('wrong module') end\nif require('util').name ~= 'util' then error('wrong init') end",
+		},
+		{
+			Name:          "unknown module",
+			Pre:           "require('unknown')",
+			ExpectedError: "module 'unknown' not found",
+		},
+		{
+			Name:          "runtime error",
+			Pre:           "require('broken.runtime')",
+			ExpectedError: "(" + filepath.Join(dir, "broken/runtime.lua") + ":L2)",
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			extra := map[string]interface{}{
+				"module_paths": []interface{}{dir},
+				"pre":          tc.Pre,
+			}
+			if tc.Sources != "" {
+				source := filepath.Join(t.TempDir(), "source.lua")
+				if err := os.WriteFile(source, []byte(tc.Sources), 0644); err != nil {
+					t.Fatal(err)
+				}
+				extra["sources"] = []interface{}{source}
+			}
+			cfg, err := Parse(logging.NoOp, config.ExtraConfig{"1234": extra}, "1234")
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+
+			p := NewPool(&cfg, func(*VM) {})
+			for i := 0; i < 2; i++ {
+				vm, err := p.Get(context.Background())
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+					return
+				}
+				err = vm.Pre()
+				p.Put(vm)
+
+				if tc.ExpectedError == "" {
+					if err != nil {
+						t.Errorf("unexpected error: %v", err)
+					}
+					continue
+				}
+				if err == nil || !strings.Contains(err.Error(), tc.ExpectedError) {
+					t.Errorf("unexpected error, have: %v, want: %s", err, tc.ExpectedError)
+				}
+			}
+		})
+	}
+}
+
+func TestParse_moduleChecksums(t *testing.T) {
+	dir := t.TempDir()
+	module := filepath.Join(dir, "mod.lua")
+	if err := os.WriteFile(module, []byte("return {}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	in := config.ExtraConfig{
+		"1234": map[string]interface{}{
+			"module_paths": []interface{}{dir},
+			"checksums": map[string]interface{}{
+				module: "sha256:0000",
+			},
+		},
+	}
+	_, err := Parse(logging.NoOp, in, "1234")
+	if e, ok := err.(ErrWrongChecksum); !ok || e.Source != module {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
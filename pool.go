@@ -0,0 +1,398 @@
+package lua
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/krakend/binder"
+	glua "github.com/yuin/gopher-lua"
+)
+
+// DefaultPoolMaxIdle is the number of idle VMs kept by a pool when the config
+// does not define it
+const DefaultPoolMaxIdle = 16
+
+// VM is a lua state with all the decorators registered and the configured
+// sources already executed, so it can be reused by several requests (one at a
+// time). The state is reset between requests: the globals and the tables of
+// the libraries and decorators are restored, the modules are unloaded and the
+// sources are executed again, so every request gets its own copy of the tables
+// they create
+type VM struct {
+	BinderWrapper
+	// Bindings holds the values the setup function needs to bind each
+	// request to the VM
+	Bindings interface{}
+	program  *Program
+	limits   Limits
+	budget   *DataBudget
+	client   *http.Client
+	batchMax int
+	cache    *SharedCache
+	metrics  *MetricsRegistry
+	stats    ExecutionMetrics
+	endpoint string
+	ctx      context.Context
+	tables   map[*glua.LTable]tableSnapshot
+	modules  map[string]glua.LValue
+	broken   bool
+}
+
+// Context returns the context of the request currently bound to the VM. The
+// decorators requiring a context must be registered with this method as a
+// getter and call it every time they use the context, as it changes with every
+// request and stage
+func (vm *VM) Context() context.Context {
+	return vm.ctx
+}
+
+// Bind sets the context of the request about to use the VM
+func (vm *VM) Bind(ctx context.Context) {
+	vm.ctx = ctx
+}
+
+// Pre executes the pre script of the config
+func (vm *VM) Pre() error {
+	return vm.run(StagePre, vm.program.Pre)
+}
+
+// Post executes the post script of the config
+func (vm *VM) Post() error {
+	return vm.run(StagePost, vm.program.Post)
+}
+
+// HasPost returns true if the config defines a post script
+func (vm *VM) HasPost() bool {
+	return vm.program.Post != nil
+}
+
+// run executes the chunk of the stage within the limits of the config and
+// records its execution metrics and span. The VMs exceeding the limits are not
+// reused
+func (vm *VM) run(stage string, proto *glua.FunctionProto) error {
+	if proto == nil {
+		return nil
+	}
+
+	parent := vm.ctx
+	ctx, span := stageSpan(parent, vm.endpoint, stage)
+	vm.ctx = ctx
+
+	start := time.Now()
+	err := vm.exec(proto)
+	vm.stats.ObserveStage(vm.endpoint, stage, time.Since(start))
+	if err != nil {
+		vm.stats.CountError(vm.endpoint, stage, ErrorType(err))
+	}
+
+	vm.ctx = parent
+	EndSpan(span, err)
+	return err
+}
+
+func (vm *VM) exec(proto *glua.FunctionProto) error {
+	var sctx *scriptContext
+	if vm.limits.bounded() {
+		parent := vm.ctx
+		ctx := parent
+		if vm.limits.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(parent, vm.limits.Timeout)
+			defer cancel()
+		}
+		vm.ctx = ctx
+		defer func() { vm.ctx = parent }()
+
+		sctx = &scriptContext{Context: ctx, budget: vm.limits.MaxInstructions}
+		vm.state.SetContext(sctx)
+		defer vm.state.RemoveContext()
+	}
+
+	err := vm.pcall(proto)
+	if err == nil {
+		return nil
+	}
+
+	if limitErr := vm.limitError(err, sctx); limitErr != nil {
+		vm.broken = true
+		return limitErr
+	}
+	return ToError(err, vm.sourceMap)
+}
+
+func (vm *VM) limitError(err error, sctx *scriptContext) error {
+	if vm.budget.exceeded() {
+		return ErrMemoryLimit
+	}
+	if apiErr, ok := err.(*glua.ApiError); ok {
+		msg := apiErr.Object.String()
+		if strings.HasSuffix(msg, "registry overflow") || strings.HasSuffix(msg, "stack overflow") {
+			return ErrMemoryLimit
+		}
+	}
+	if sctx == nil {
+		return nil
+	}
+	switch {
+	case sctx.exhausted():
+		return ErrScriptBudgetExceeded
+	case sctx.Context.Err() == context.DeadlineExceeded:
+		return ErrScriptTimeout
+	}
+	return sctx.Context.Err()
+}
+
+func (vm *VM) Close() {
+	vms.Delete(vm.binder)
+	vm.binder.Close()
+}
+
+// snapshot records the content of the tables reachable from the globals (the
+// libraries and the decorators) before executing the sources
+func (vm *VM) snapshot() {
+	vm.tables = map[*glua.LTable]tableSnapshot{}
+	vm.record(vm.state.G.Global)
+}
+
+type tableSnapshot struct {
+	fields    map[glua.LValue]glua.LValue
+	metatable glua.LValue
+}
+
+func (vm *VM) record(t *glua.LTable) {
+	if _, ok := vm.tables[t]; ok {
+		return
+	}
+	snap := tableSnapshot{fields: map[glua.LValue]glua.LValue{}, metatable: t.Metatable}
+	vm.tables[t] = snap
+	t.ForEach(func(k, v glua.LValue) {
+		snap.fields[k] = v
+		if nested, ok := v.(*glua.LTable); ok {
+			vm.record(nested)
+		}
+	})
+}
+
+// reset restores the tables of the snapshot, unloads the modules and executes
+// the sources again, so the next request does not see the values stored by the
+// last one. The VMs failing to reset are not reused
+func (vm *VM) reset() {
+	vm.state.SetTop(0)
+	vm.ctx = context.Background()
+	vm.budget.reset()
+
+	for t, snap := range vm.tables {
+		snap.restore(t)
+	}
+	if vm.modules != nil {
+		vm.modules = map[string]glua.LValue{}
+	}
+
+	if err := vm.exec(vm.program.Sources); err != nil {
+		vm.broken = true
+	}
+	vm.state.SetTop(0)
+	vm.budget.reset()
+}
+
+// restore removes the fields added to the table and sets the original values
+// of the rest
+func (s tableSnapshot) restore(t *glua.LTable) {
+	var added []glua.LValue
+	t.ForEach(func(k, _ glua.LValue) {
+		if _, ok := s.fields[k]; !ok {
+			added = append(added, k)
+		}
+	})
+	for _, k := range added {
+		t.RawSet(k, glua.LNil)
+	}
+	for k, v := range s.fields {
+		t.RawSet(k, v)
+	}
+	t.Metatable = s.metatable
+}
+
+// vms keeps the VM owning each binder, so the decorators can access the
+// resources of their VM
+var vms sync.Map
+
+func vmOf(b *binder.Binder) *VM {
+	if v, ok := vms.Load(b); ok {
+		return v.(*VM)
+	}
+	return nil
+}
+
+// Pool keeps a set of ready to use VMs for a given config
+type Pool struct {
+	cfg   *Config
+	setup func(*VM)
+	idle  chan *VM
+	slots chan struct{}
+	err   error
+}
+
+// NewPool returns a pool of VMs for the given config. The setup function is
+// called once per VM, before executing the sources, and it is the place to
+// register the decorators and the request tables. If the lua states can not be
+// created with the linked version of the binder, the pool returns the error
+// from Get.
+func NewPool(cfg *Config, setup func(*VM)) *Pool {
+	if cfg.programs == nil {
+		cfg.programs = &programCache{}
+	}
+	p := &Pool{
+		cfg:   cfg,
+		setup: setup,
+		idle:  make(chan *VM, cfg.Pool.MaxIdle),
+		err:   errStateFields,
+	}
+	if cfg.Pool.MaxSize > 0 {
+		p.slots = make(chan struct{}, cfg.Pool.MaxSize)
+	}
+	return p
+}
+
+// Get returns an idle VM or creates a new one. If the pool already reached its
+// max size, it waits until another request releases a VM or the context is done.
+// Idle VMs built with an outdated version of the sources are discarded.
+func (p *Pool) Get(ctx context.Context) (*VM, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	program, err := p.cfg.Program()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		vm, err := p.get(ctx, program)
+		if err != nil || vm.program == program {
+			return vm, err
+		}
+		p.Discard(vm)
+	}
+}
+
+func (p *Pool) get(ctx context.Context, program *Program) (*VM, error) {
+	select {
+	case vm := <-p.idle:
+		return vm, nil
+	default:
+	}
+
+	if p.slots != nil {
+		select {
+		case vm := <-p.idle:
+			return vm, nil
+		case p.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	vm, err := p.newVM(ctx, program)
+	if err != nil {
+		p.release()
+		return nil, err
+	}
+	return vm, nil
+}
+
+// Put returns the VM to the pool. The VM is closed if the pool already has
+// enough idle VMs.
+func (p *Pool) Put(vm *VM) {
+	if vm.broken || len(p.idle) == cap(p.idle) {
+		p.Discard(vm)
+		return
+	}
+
+	vm.reset()
+	if vm.broken {
+		p.Discard(vm)
+		return
+	}
+
+	select {
+	case p.idle <- vm:
+	default:
+		p.Discard(vm)
+	}
+}
+
+// Discard closes the VM without returning it to the pool
+func (p *Pool) Discard(vm *VM) {
+	vm.Close()
+	p.release()
+}
+
+func (p *Pool) release() {
+	if p.slots != nil {
+		<-p.slots
+	}
+}
+
+func (p *Pool) newVM(ctx context.Context, program *Program) (*VM, error) {
+	vm := &VM{
+		BinderWrapper: NewBinderWrapper(binder.Options{
+			SkipOpenLibs:        !p.cfg.AllowOpenLibs,
+			IncludeGoStackTrace: true,
+			RegistrySize:        p.cfg.Limits.RegistrySize,
+			CallStackSize:       p.cfg.Limits.CallStackSize,
+		}),
+		program:  program,
+		limits:   p.cfg.Limits,
+		client:   p.cfg.HTTPClient,
+		batchMax: p.cfg.BatchConcurrency,
+		cache:    p.cfg.SharedCache,
+		metrics:  p.cfg.Metrics,
+		stats:    p.cfg.ExecutionMetrics,
+		endpoint: p.cfg.Endpoint,
+		ctx:      ctx,
+	}
+
+	if vm.stats == nil {
+		vm.stats = DefaultExecutionMetrics
+	}
+	vm.stats.CountVM(vm.endpoint)
+
+	if !p.cfg.AllowOpenLibs {
+		openLibs(vm.state, p.cfg.OpenLibs)
+		closeFileLoaders(vm.state)
+	}
+	denyFunctions(vm.state, p.cfg.DeniedFunctions)
+
+	if p.cfg.Limits.MaxDataSize > 0 {
+		vm.budget = &DataBudget{max: p.cfg.Limits.MaxDataSize}
+	}
+	vms.Store(vm.binder, vm)
+
+	p.setup(vm)
+
+	// executing an empty chunk loads the registered decorators into the state,
+	// so they are part of the globals snapshot
+	if err := vm.binder.DoString(""); err != nil {
+		vm.Close()
+		return nil, err
+	}
+
+	if program.Modules != nil {
+		vm.modules = map[string]glua.LValue{}
+		vm.state.SetGlobal("require", vm.state.NewFunction(vm.require))
+	}
+	vm.snapshot()
+
+	*vm.sourceMap = program.SourceMap
+	if err := vm.run(StageSources, program.Sources); err != nil {
+		vm.Close()
+		return nil, err
+	}
+
+	vm.ctx = context.Background()
+
+	return vm, nil
+}
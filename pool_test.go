@@ -0,0 +1,149 @@
+package lua
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/logging"
+)
+
+func TestPool_reuse(t *testing.T) {
+	cfg := &Config{
+		Sources:      []string{"lua/factorial.lua"},
+		SourceLoader: onceLoader{"lua/factorial.lua": "counter = 0\nfunction fact (n)\n  return n\nend"},
+		Pool:         PoolConfig{MaxIdle: 1},
+	}
+
+	setups := 0
+	p := NewPool(cfg, func(*VM) { setups++ })
+
+	for i := 0; i < 3; i++ {
+		vm, err := p.Get(context.Background())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if err := vm.WithCode("pre-script", "if leaked ~= nil then error('leaked global') end\nleaked = true\ncounter = counter + 1\nif counter ~= 1 then error('counter not restored') end"); err != nil {
+			t.Error(err)
+		}
+		p.Put(vm)
+	}
+
+	if setups != 1 {
+		t.Errorf("unexpected number of VMs created: %d", setups)
+	}
+}
+
+func TestPool_isolation(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "state.lua")
+	if err := os.WriteFile(source, []byte("state = {}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Parse(logging.NoOp, config.ExtraConfig{
+		"lua": map[string]interface{}{
+			"sources": []interface{}{source},
+		},
+	}, "lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewPool(&cfg, func(*VM) {})
+	for _, user := range []string{"alice", "bob"} {
+		vm, err := p.Get(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		code := "if state.user ~= nil then error('state shared with ' .. state.user) end\n" +
+			"if table.user ~= nil or package.loaded.user ~= nil then error('library shared with ' .. table.user) end\n" +
+			"state.user = '" + user + "'\ntable.user = state.user\npackage.loaded.user = state.user"
+		if err := vm.WithCode("pre-script", code); err != nil {
+			t.Errorf("%s: %v", user, err)
+		}
+		p.Put(vm)
+	}
+	if len(p.idle) != 1 {
+		t.Errorf("the VM has not been reused: %d idle VMs", len(p.idle))
+	}
+}
+
+func TestPool_maxIdle(t *testing.T) {
+	cfg := &Config{
+		SourceLoader: onceLoader{},
+		Pool:         PoolConfig{MaxIdle: 0},
+	}
+
+	setups := 0
+	p := NewPool(cfg, func(*VM) { setups++ })
+
+	for i := 0; i < 3; i++ {
+		vm, err := p.Get(context.Background())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		p.Put(vm)
+	}
+
+	if setups != 3 {
+		t.Errorf("unexpected number of VMs created: %d", setups)
+	}
+}
+
+func TestPool_maxSize(t *testing.T) {
+	cfg := &Config{
+		SourceLoader: onceLoader{},
+		Pool:         PoolConfig{MaxSize: 1, MaxIdle: 1},
+	}
+
+	p := NewPool(cfg, func(*VM) {})
+
+	vm, err := p.Get(context.Background())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := p.Get(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		p.Put(vm)
+	}()
+
+	other, err := p.Get(context.Background())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if other != vm {
+		t.Error("the released VM was not reused")
+	}
+	p.Put(other)
+}
+
+func TestPool_sourceError(t *testing.T) {
+	cfg := &Config{
+		Sources:      []string{"unknown.lua"},
+		SourceLoader: onceLoader{},
+		Pool:         PoolConfig{MaxSize: 1},
+	}
+
+	p := NewPool(cfg, func(*VM) {})
+
+	for i := 0; i < 2; i++ {
+		if _, err := p.Get(context.Background()); err != ErrUnknownSource("unknown.lua") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package lua
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PrometheusExporter writes the metrics using the prometheus text format
+type PrometheusExporter struct {
+	W io.Writer
+}
+
+func (e PrometheusExporter) Export(metrics []Metric) error {
+	w := bufio.NewWriter(e.W)
+	last := ""
+	for _, m := range metrics {
+		if m.Name != last {
+			w.WriteString("# TYPE " + m.Name + " " + string(m.Type) + "\n")
+			last = m.Name
+		}
+
+		if m.Type != HistogramMetric {
+			writeSample(w, m.Name, m.Labels, "", m.Value)
+			continue
+		}
+		for _, b := range m.Buckets {
+			writeSample(w, m.Name+"_bucket", m.Labels, formatFloat(b.UpperBound), float64(b.Count))
+		}
+		writeSample(w, m.Name+"_bucket", m.Labels, "+Inf", float64(m.Count))
+		writeSample(w, m.Name+"_sum", m.Labels, "", m.Sum)
+		writeSample(w, m.Name+"_count", m.Labels, "", float64(m.Count))
+	}
+	return w.Flush()
+}
+
+// PrometheusHandler serves the metrics of the registry using the prometheus text format
+func PrometheusHandler(r *MetricsRegistry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Export(PrometheusExporter{W: w})
+	})
+}
+
+func writeSample(w *bufio.Writer, name string, labels map[string]string, le string, v float64) {
+	w.WriteString(name)
+
+	names := make([]string, 0, len(labels))
+	for l := range labels {
+		names = append(names, l)
+	}
+	sort.Strings(names)
+	if le != "" {
+		names = append(names, "le")
+	}
+
+	if len(names) > 0 {
+		w.WriteByte('{')
+		for i, l := range names {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			value := labels[l]
+			if l == "le" && le != "" {
+				value = le
+			}
+			w.WriteString(l + `="` + escapeLabel(value) + `"`)
+		}
+		w.WriteByte('}')
+	}
+
+	w.WriteString(" " + formatFloat(v) + "\n")
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
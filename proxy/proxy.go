@@ -4,7 +4,6 @@ import (
 	"context"
 	"errors"
 
-	"github.com/krakend/binder"
 	lua "github.com/krakend/krakend-lua/v2"
 	"github.com/krakend/krakend-lua/v2/decorator"
 	"github.com/luraproject/lura/v2/config"
@@ -27,15 +26,17 @@ func ProxyFactory(l logging.Logger, pf proxy.Factory) proxy.Factory {
 
 		cfg, err := lua.Parse(l, remote.ExtraConfig, ProxyNamespace)
 		if err != nil {
-			if err != lua.ErrNoExtraConfig {
-				l.Debug(logPrefix, err)
+			if err == lua.ErrNoExtraConfig {
+				return next, nil
 			}
-			return next, nil
+			l.Error(logPrefix, err)
+			return failingProxy(err), nil
 		}
 
+		cfg.Endpoint = remote.Endpoint
 		l.Debug(logPrefix, "Middleware is now ready")
 
-		return New(cfg, next), nil
+		return newProxy(l, logPrefix, cfg, next), nil
 	})
 }
 
@@ -46,13 +47,23 @@ func BackendFactory(l logging.Logger, bf proxy.BackendFactory) proxy.BackendFact
 
 		cfg, err := lua.Parse(l, remote.ExtraConfig, BackendNamespace)
 		if err != nil {
-			if err != lua.ErrNoExtraConfig {
-				l.Debug(logPrefix, err)
+			if err == lua.ErrNoExtraConfig {
+				return next
 			}
-			return next
+			l.Error(logPrefix, err)
+			return failingProxy(err)
 		}
+		cfg.Endpoint = remote.URLPattern
 
-		return New(cfg, next)
+		return newProxy(l, logPrefix, cfg, next)
+	}
+}
+
+// failingProxy rejects all the requests with the error of a lua config that
+// can not be executed, so the scripts are never skipped
+func failingProxy(err error) proxy.Proxy {
+	return func(_ context.Context, _ *proxy.Request) (*proxy.Response, error) {
+		return nil, err
 	}
 }
 
@@ -66,35 +77,63 @@ func RegisterDecorator(f decorator.Decorator) {
 	localRegisterer.decorators = append(localRegisterer.decorators, f)
 }
 
+type bindings struct {
+	request  *ProxyRequest
+	response *ProxyResponse
+}
+
 func New(cfg lua.Config, next proxy.Proxy) proxy.Proxy {
-	return func(ctx context.Context, req *proxy.Request) (resp *proxy.Response, err error) {
-		b := lua.NewBinderWrapper(binder.Options{
-			SkipOpenLibs:        !cfg.AllowOpenLibs,
-			IncludeGoStackTrace: true,
-		})
-		defer b.GetBinder().Close()
-
-		decorator.RegisterErrors(b.GetBinder())
-		decorator.RegisterNil(b.GetBinder())
-		decorator.RegisterLuaTable(b.GetBinder())
-		decorator.RegisterLuaList(b.GetBinder())
-		decorator.RegisterHTTPRequest(ctx, b.GetBinder())
+	return newProxy(logging.NoOp, "[Lua]", cfg, next)
+}
+
+func newProxy(l logging.Logger, logPrefix string, cfg lua.Config, next proxy.Proxy) proxy.Proxy {
+	pool := lua.NewPool(&cfg, func(vm *lua.VM) {
+		bd := &bindings{
+			request:  &ProxyRequest{},
+			response: &ProxyResponse{},
+		}
+		vm.Bindings = bd
+
+		decorator.RegisterErrors(vm.GetBinder())
+		decorator.RegisterNil(vm.GetBinder())
+		decorator.RegisterLuaTable(vm.GetBinder())
+		decorator.RegisterLuaList(vm.GetBinder())
+		decorator.RegisterJSON(vm.GetBinder())
+		decorator.RegisterSharedCache(vm.GetBinder())
+		decorator.RegisterMetrics(vm.GetBinder())
+		decorator.RegisterLogger(vm.GetBinder(), l, logPrefix)
+		decorator.RegisterHTTPRequestFunc(vm.Context, vm.GetBinder())
+		decorator.RegisterTrace(vm.Context, vm.GetBinder())
 		for _, f := range localRegisterer.decorators {
-			f(b.GetBinder())
+			f(vm.GetBinder())
 		}
 
-		registerRequestTable(req, b.GetBinder())
+		registerRequestTable(bd.request, vm.GetBinder())
+		registerResponseTable(bd.response, vm.GetBinder())
+	})
 
-		if err := b.WithConfig(&cfg); err != nil {
+	return func(ctx context.Context, req *proxy.Request) (resp *proxy.Response, err error) {
+		vm, err := pool.Get(ctx)
+		if err != nil {
 			return nil, err
 		}
+		defer pool.Put(vm)
+
+		bd := vm.Bindings.(*bindings)
+		defer bd.request.bind(nil)
+		defer bd.response.bind(nil)
+
+		vm.Bind(ctx)
+		bd.request.bind(req)
 
-		if err := b.WithCode("pre-script", cfg.PreCode); err != nil {
+		if err := vm.Pre(); err != nil {
 			return nil, err
 		}
 
 		if !cfg.SkipNext {
-			resp, err = next(ctx, req)
+			nextCtx, span := lua.StartSpan(ctx, "lua next")
+			resp, err = next(nextCtx, req)
+			lua.EndSpan(span, err)
 			if err != nil {
 				return resp, lua.ToError(err, nil)
 			}
@@ -102,9 +141,9 @@ func New(cfg lua.Config, next proxy.Proxy) proxy.Proxy {
 			resp = &proxy.Response{}
 		}
 
-		registerResponseTable(resp, b.GetBinder())
+		bd.response.bind(resp)
 
-		if err := b.WithCode("post-script", cfg.PostCode); err != nil {
+		if err := vm.Post(); err != nil {
 			return nil, err
 		}
 
@@ -14,6 +14,22 @@ import (
 var localResponse *proxy.Response
 
 func BenchmarkProxyFactory(b *testing.B) {
+	benchmarkProxyFactory(b, nil)
+}
+
+func BenchmarkProxyFactory_withoutPool(b *testing.B) {
+	benchmarkProxyFactory(b, map[string]interface{}{"max_idle": 0.0})
+}
+
+func BenchmarkProxyFactory_parallel(b *testing.B) {
+	benchmarkProxyFactoryParallel(b, nil)
+}
+
+func BenchmarkProxyFactory_parallelWithoutPool(b *testing.B) {
+	benchmarkProxyFactoryParallel(b, map[string]interface{}{"max_idle": 0.0})
+}
+
+func benchmarkProxyFactory(b *testing.B, pool map[string]interface{}) {
 	buff := bytes.NewBuffer(make([]byte, 1024))
 	logger, err := logging.NewLogger("ERROR", buff, "pref")
 	if err != nil {
@@ -41,6 +57,7 @@ func BenchmarkProxyFactory(b *testing.B) {
 				"sources": []interface{}{
 					"../lua/factorial.lua",
 				},
+				"pool": pool,
 
 				"pre": `local req = request.load()
 		req:method("POST")
@@ -87,6 +104,57 @@ func BenchmarkProxyFactory(b *testing.B) {
 	localResponse = resp
 }
 
+func benchmarkProxyFactoryParallel(b *testing.B, pool map[string]interface{}) {
+	dummyProxyFactory := proxy.FactoryFunc(func(_ *config.EndpointConfig) (proxy.Proxy, error) {
+		return func(_ context.Context, _ *proxy.Request) (*proxy.Response, error) {
+			return &proxy.Response{
+				Data: map[string]interface{}{"ok": true},
+				Metadata: proxy.Metadata{
+					Headers: map[string][]string{},
+				},
+			}, nil
+		}, nil
+	})
+
+	URL, _ := url.Parse("https://some.host.tld/path/to/resource?and=querystring")
+
+	prxy, err := ProxyFactory(logging.NoOp, dummyProxyFactory).New(&config.EndpointConfig{
+		Endpoint: "/",
+		ExtraConfig: config.ExtraConfig{
+			ProxyNamespace: map[string]interface{}{
+				"sources": []interface{}{
+					"../lua/factorial.lua",
+				},
+				"pool": pool,
+				"pre": `local req = request.load()
+		req:method("POST")
+		req:headers("Accept", "application/xml")`,
+				"post": `local resp = response.load()
+		resp:data():set("bar", fact(5))`,
+			},
+		},
+	})
+
+	if err != nil {
+		b.Error(err)
+	}
+
+	b.ResetTimer()
+	b.ReportAllocs()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			prxy(context.Background(), &proxy.Request{
+				Method:  "GET",
+				Path:    "/some-path",
+				Params:  map[string]string{"Id": "42"},
+				Headers: map[string][]string{},
+				URL:     URL,
+			})
+		}
+	})
+}
+
 func BenchmarkProxyFactoryWithCustomError(b *testing.B) {
 	buff := bytes.NewBuffer(make([]byte, 1024))
 	logger, err := logging.NewLogger("ERROR", buff, "pref")
@@ -173,7 +241,7 @@ func BenchmarkProxyFactoryWithLuaError(b *testing.B) {
 					"../lua/factorial.lua",
 				},
 
-				"pre": "lokal t = 1\ncustom_error(\"meh\")",
+				"pre": "local t = 1\nerror(\"meh\")",
 			},
 		},
 	})
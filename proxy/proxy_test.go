@@ -6,9 +6,13 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	lua "github.com/krakend/krakend-lua/v2"
 	"github.com/luraproject/lura/v2/config"
@@ -38,6 +42,16 @@ func TestProxyFactory_luaError(t *testing.T) {
 			},
 			ExpectedError: "'a': parse error (pre-script:L1)",
 		},
+		{
+			Name: "Pre: Missing source",
+			Cfg: map[string]interface{}{
+				"sources": []interface{}{
+					"../lua/unknown.lua",
+				},
+				"pre": "custom_error(\"denied\", 401)",
+			},
+			ExpectedError: "lua: unable to load required source ../lua/unknown.lua",
+		},
 		{
 			Name: "Pre: Inline semicolon separated",
 			Cfg: map[string]interface{}{
@@ -213,6 +227,14 @@ func TestProxyFactory_luaError(t *testing.T) {
 			},
 			ExpectedError: "invalid header value, must be a luaList (post-script:L1)",
 		},
+		{
+			Name: "Pre: egress denied",
+			Cfg: map[string]interface{}{
+				"egress": map[string]interface{}{"allowed_hosts": []interface{}{"api.example.com"}},
+				"pre":    `http_response.new("http://169.254.169.254/latest/meta-data")`,
+			},
+			ExpectedError: "lua: egress to 169.254.169.254 is not allowed (pre-script:L1)",
+		},
 	}
 
 	for _, test := range luaErrorTestTable {
@@ -284,6 +306,335 @@ func TestProxyFactory_errorHTTPWithContentType(t *testing.T) {
 	testProxyFactoryPostError(t, `custom_error('{"msg":"expect me"}', 404, 'application/json')`, `{"msg":"expect me"}`, "application/json", true, 404)
 }
 
+func TestProxyFactory_log(t *testing.T) {
+	buff := new(bytes.Buffer)
+	logger, err := logging.NewLogger("INFO", buff, "pref")
+	if err != nil {
+		t.Error("building the logger:", err.Error())
+		return
+	}
+
+	next := func(_ context.Context, _ *proxy.Request) (*proxy.Response, error) {
+		return &proxy.Response{}, nil
+	}
+	extra := config.ExtraConfig{
+		ProxyNamespace: map[string]interface{}{"pre": `log.info("hello from the endpoint", 42)`},
+		BackendNamespace: map[string]interface{}{"pre": `log.debug("hidden")
+log.warning("hello from the backend")`},
+	}
+
+	prxy, err := ProxyFactory(logger, proxy.FactoryFunc(func(_ *config.EndpointConfig) (proxy.Proxy, error) {
+		return next, nil
+	})).New(&config.EndpointConfig{Endpoint: "/foo", ExtraConfig: extra})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	backend := BackendFactory(logger, func(_ *config.Backend) proxy.Proxy {
+		return next
+	})(&config.Backend{URLPattern: "/bar", ExtraConfig: extra})
+
+	for _, p := range []proxy.Proxy{prxy, backend} {
+		if _, err := p(context.Background(), &proxy.Request{}); err != nil {
+			t.Error(err)
+		}
+	}
+
+	logs := buff.String()
+	for _, msg := range []string{
+		"pref INFO: [ENDPOINT: /foo][Lua] hello from the endpoint 42",
+		"pref WARNING: [BACKEND: /bar][Lua] hello from the backend",
+	} {
+		if !strings.Contains(logs, msg) {
+			t.Errorf("%q not found in the logs:\n%s", msg, logs)
+		}
+	}
+	if strings.Contains(logs, "hidden") {
+		t.Errorf("unexpected debug message in the logs:\n%s", logs)
+	}
+}
+
+func TestProxyFactory_executionMetrics(t *testing.T) {
+	registry := lua.NewMetricsRegistry()
+	defer func(m lua.ExecutionMetrics) { lua.DefaultExecutionMetrics = m }(lua.DefaultExecutionMetrics)
+	lua.DefaultExecutionMetrics = lua.NewExecutionMetrics(registry)
+
+	prxy, err := ProxyFactory(logging.NoOp, proxy.FactoryFunc(func(_ *config.EndpointConfig) (proxy.Proxy, error) {
+		return func(_ context.Context, _ *proxy.Request) (*proxy.Response, error) {
+			return &proxy.Response{}, nil
+		}, nil
+	})).New(&config.EndpointConfig{
+		Endpoint: "/foo",
+		ExtraConfig: config.ExtraConfig{
+			ProxyNamespace: map[string]interface{}{
+				"pre":  `if request.load():headers("X-Fail") ~= "" then custom_error("failed", 418) end`,
+				"post": `response.load():isComplete(true)`,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, h := range []string{"", "yes", ""} {
+		prxy(context.Background(), &proxy.Request{
+			Params:  map[string]string{},
+			Headers: map[string][]string{"X-Fail": {h}},
+		})
+	}
+
+	e := &lua.MemoryExporter{}
+	registry.Export(e)
+
+	for _, tc := range []struct {
+		name   string
+		labels map[string]string
+		count  uint64
+		value  float64
+	}{
+		{name: "krakend_lua_stage_duration_seconds", labels: map[string]string{"endpoint": "/foo", "stage": "pre"}, count: 3},
+		{name: "krakend_lua_stage_duration_seconds", labels: map[string]string{"endpoint": "/foo", "stage": "post"}, count: 2},
+		{name: "krakend_lua_errors_total", labels: map[string]string{"endpoint": "/foo", "stage": "pre", "type": "ErrInternalHTTP"}, value: 1},
+		{name: "krakend_lua_vms_created_total", labels: map[string]string{"endpoint": "/foo"}, value: 1},
+	} {
+		m, ok := e.Get(tc.name, tc.labels)
+		if !ok {
+			t.Errorf("%s %v not found", tc.name, tc.labels)
+			continue
+		}
+		if m.Count != tc.count || m.Value != tc.value {
+			t.Errorf("unexpected %s %v: %+v", tc.name, tc.labels, m)
+		}
+	}
+}
+
+func TestNew_concurrent(t *testing.T) {
+	prxy := New(lua.Config{PreCode: `request.load():headers("X-Lua", "yes")`}, func(_ context.Context, r *proxy.Request) (*proxy.Response, error) {
+		return &proxy.Response{Data: map[string]interface{}{"lua": r.Headers["X-Lua"]}}, nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := prxy(context.Background(), &proxy.Request{Headers: map[string][]string{}})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if v, ok := resp.Data["lua"].([]string); !ok || len(v) != 1 || v[0] != "yes" {
+				t.Errorf("unexpected response: %v", resp.Data)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestProxyFactory_batchLimits(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		w.Write([]byte("0123456789"))
+	}))
+	defer ts.Close()
+
+	batch := `local calls = {}
+for i=1,6 do calls[i] = {url = "` + ts.URL + `"} end
+http_request.batch(calls)`
+
+	for _, tc := range []struct {
+		Name          string
+		Cfg           map[string]interface{}
+		ExpectedError error
+	}{
+		{
+			Name: "concurrency",
+			Cfg: map[string]interface{}{
+				"batch_concurrency": 2.0,
+				"pre":               batch,
+			},
+		},
+		{
+			Name: "data size of the bodies",
+			Cfg: map[string]interface{}{
+				"max_data_size": 32.0,
+				"pre":           batch,
+			},
+			ExpectedError: lua.ErrMemoryLimit,
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			mu.Lock()
+			maxInFlight = 0
+			mu.Unlock()
+
+			prxy := New(mustParse(t, tc.Cfg), func(_ context.Context, _ *proxy.Request) (*proxy.Response, error) {
+				return &proxy.Response{}, nil
+			})
+			_, err := prxy(context.Background(), &proxy.Request{Headers: map[string][]string{}})
+			if !errors.Is(err, tc.ExpectedError) {
+				t.Errorf("unexpected error: %v", err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if limit, ok := tc.Cfg["batch_concurrency"].(float64); ok && maxInFlight > int(limit) {
+				t.Errorf("too many concurrent calls: %d", maxInFlight)
+			}
+		})
+	}
+}
+
+func mustParse(t *testing.T, cfg map[string]interface{}) lua.Config {
+	t.Helper()
+	res, err := lua.Parse(logging.NoOp, config.ExtraConfig{ProxyNamespace: cfg}, ProxyNamespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestProxyFactory_limits(t *testing.T) {
+	for _, tc := range []struct {
+		Name          string
+		Cfg           map[string]interface{}
+		ExpectedError error
+	}{
+		{
+			Name: "pre timeout",
+			Cfg: map[string]interface{}{
+				"timeout": "10ms",
+				"pre":     "while true do end",
+			},
+			ExpectedError: lua.ErrScriptTimeout,
+		},
+		{
+			Name: "post timeout",
+			Cfg: map[string]interface{}{
+				"timeout": "10ms",
+				"post":    "while true do end",
+			},
+			ExpectedError: lua.ErrScriptTimeout,
+		},
+		{
+			Name: "sources within the timeout",
+			Cfg: map[string]interface{}{
+				"sources": []interface{}{"../lua/factorial.lua"},
+				"timeout": "10ms",
+				"pre":     "local t = 1",
+			},
+		},
+		{
+			Name: "pre budget",
+			Cfg: map[string]interface{}{
+				"max_instructions": 1000.0,
+				"pre":              "for i=1,1000 do local a = i end",
+			},
+			ExpectedError: lua.ErrScriptBudgetExceeded,
+		},
+		{
+			Name: "post budget",
+			Cfg: map[string]interface{}{
+				"sources":          []interface{}{"../lua/factorial.lua"},
+				"max_instructions": 100.0,
+				"post":             "fact(50)",
+			},
+			ExpectedError: lua.ErrScriptBudgetExceeded,
+		},
+		{
+			Name: "data size",
+			Cfg: map[string]interface{}{
+				"max_data_size": 64.0,
+				"pre":           "local t = luaTable.new()\nfor i=1,10 do t:set('key' .. i, 'some value to store') end",
+			},
+			ExpectedError: lua.ErrMemoryLimit,
+		},
+		{
+			Name: "data size of the body",
+			Cfg: map[string]interface{}{
+				"max_data_size": 4.0,
+				"pre":           "local r = request.load()\nr:body('a body longer than the limit')\nr:body()",
+			},
+			ExpectedError: lua.ErrMemoryLimit,
+		},
+		{
+			Name: "data size of the body read twice",
+			Cfg: map[string]interface{}{
+				"max_data_size": 15.0,
+				"pre":           "local r = request.load()\nr:body('0123456789')\nr:body()\nr:body()",
+			},
+		},
+		{
+			Name: "call stack size",
+			Cfg: map[string]interface{}{
+				"call_stack_size": 32.0,
+				"pre":             "local function f(n) if n == 0 then return 0 end return 1 + f(n-1) end\nf(100)",
+			},
+			ExpectedError: lua.ErrMemoryLimit,
+		},
+		{
+			Name: "registry size",
+			Cfg: map[string]interface{}{
+				"registry_size": 256.0,
+				"pre":           "local t = {}\nfor i=1,1000 do t[i] = i end\nlocal function f(...) return select('#', ...) end\nf(unpack(t))",
+			},
+			ExpectedError: lua.ErrMemoryLimit,
+		},
+		{
+			Name: "within the limits",
+			Cfg: map[string]interface{}{
+				"sources":          []interface{}{"../lua/factorial.lua"},
+				"timeout":          "1s",
+				"max_instructions": 1000.0,
+				"pre":              "fact(5)",
+				"post":             "fact(5)",
+			},
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			next := proxy.FactoryFunc(func(_ *config.EndpointConfig) (proxy.Proxy, error) {
+				return func(_ context.Context, _ *proxy.Request) (*proxy.Response, error) {
+					return &proxy.Response{}, nil
+				}, nil
+			})
+
+			prxy, err := ProxyFactory(logging.NoOp, next).New(&config.EndpointConfig{
+				Endpoint: "/",
+				ExtraConfig: config.ExtraConfig{
+					ProxyNamespace: tc.Cfg,
+				},
+			})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			for i := 0; i < 2; i++ {
+				_, err = prxy(context.Background(), &proxy.Request{
+					Params:  map[string]string{},
+					Headers: map[string][]string{},
+				})
+				if err != tc.ExpectedError {
+					t.Errorf("unexpected error, have: %v, want: %v", err, tc.ExpectedError)
+				}
+			}
+		})
+	}
+}
+
 func testProxyFactoryError(t *testing.T, code, errMsg, contentType string, isHTTP bool, statusCode int) {
 	buff := bytes.NewBuffer(make([]byte, 1024))
 	logger, err := logging.NewLogger("ERROR", buff, "pref")
@@ -14,12 +14,14 @@ import (
 	glua "github.com/yuin/gopher-lua"
 )
 
-func registerRequestTable(req *proxy.Request, b *binder.Binder) {
-	r := &ProxyRequest{req}
-
+func registerRequestTable(r *ProxyRequest, b *binder.Binder) {
+	r.budget = lua.DataBudgetOf(b)
 	t := b.Table("request")
 
 	t.Static("load", func(c *binder.Context) error {
+		if r.Request == nil {
+			return errRequestExpected
+		}
 		c.Push().Data(r, "request")
 		return nil
 	})
@@ -36,10 +38,18 @@ func registerRequestTable(req *proxy.Request, b *binder.Binder) {
 
 type ProxyRequest struct {
 	*proxy.Request
+	budget *lua.DataBudget
+	// charged is true once the body is charged to the budget
+	charged bool
 }
 
 var errRequestExpected = errors.New("request expected")
 
+func (r *ProxyRequest) bind(req *proxy.Request) {
+	r.Request = req
+	r.charged = false
+}
+
 func (*ProxyRequest) method(c *binder.Context) error {
 	req, ok := c.Arg(1).Data().(*ProxyRequest)
 	if !ok {
@@ -200,6 +210,7 @@ func (*ProxyRequest) body(c *binder.Context) error {
 
 	if c.Top() == 2 {
 		req.Body = io.NopCloser(bytes.NewBufferString(c.Arg(2).String()))
+		req.charged = false
 		return nil
 	}
 
@@ -209,6 +220,12 @@ func (*ProxyRequest) body(c *binder.Context) error {
 		req.Body.Close()
 	}
 	req.Body = io.NopCloser(bytes.NewBuffer(b))
+	if !req.charged {
+		req.charged = true
+		if err := req.budget.Consume(len(b)); err != nil {
+			return err
+		}
+	}
 	c.Push().String(string(b))
 
 	return nil
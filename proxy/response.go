@@ -13,18 +13,14 @@ import (
 	glua "github.com/yuin/gopher-lua"
 )
 
-func registerResponseTable(resp *proxy.Response, b *binder.Binder) {
-	r := &ProxyResponse{resp}
-	if r.Metadata.Headers == nil {
-		r.Metadata.Headers = map[string][]string{}
-	}
-	if r.Data == nil {
-		r.Data = map[string]interface{}{}
-	}
-
+func registerResponseTable(r *ProxyResponse, b *binder.Binder) {
+	r.budget = lua.DataBudgetOf(b)
 	t := b.Table("response")
 
 	t.Static("load", func(c *binder.Context) error {
+		if r.Response == nil {
+			return errResponseExpected
+		}
 		c.Push().Data(r, "response")
 		return nil
 	})
@@ -39,10 +35,26 @@ func registerResponseTable(resp *proxy.Response, b *binder.Binder) {
 
 type ProxyResponse struct {
 	*proxy.Response
+	budget *lua.DataBudget
+	// charged is true once the body is charged to the budget
+	charged bool
 }
 
 var errResponseExpected = errors.New("response expected")
 
+func (r *ProxyResponse) bind(resp *proxy.Response) {
+	if resp != nil {
+		if resp.Metadata.Headers == nil {
+			resp.Metadata.Headers = map[string][]string{}
+		}
+		if resp.Data == nil {
+			resp.Data = map[string]interface{}{}
+		}
+	}
+	r.Response = resp
+	r.charged = false
+}
+
 func (*ProxyResponse) isComplete(c *binder.Context) error {
 	resp, ok := c.Arg(1).Data().(*ProxyResponse)
 	if !ok {
@@ -150,6 +162,7 @@ func (*ProxyResponse) body(c *binder.Context) error {
 
 	if c.Top() == 2 {
 		resp.Io = bytes.NewBufferString(c.Arg(2).String())
+		resp.charged = false
 		return nil
 	}
 
@@ -158,6 +171,12 @@ func (*ProxyResponse) body(c *binder.Context) error {
 		b, _ = io.ReadAll(resp.Io)
 	}
 	resp.Io = bytes.NewBuffer(b)
+	if !resp.charged {
+		resp.charged = true
+		if err := resp.budget.Consume(len(b)); err != nil {
+			return err
+		}
+	}
 	c.Push().String(string(b))
 
 	return nil
@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/logging"
+	"github.com/luraproject/lura/v2/proxy"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestProxyFactory_tracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	traceparent := make(chan string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		traceparent <- r.Header.Get("traceparent")
+	}))
+	defer ts.Close()
+
+	prxy, err := ProxyFactory(logging.NoOp, proxy.FactoryFunc(func(_ *config.EndpointConfig) (proxy.Proxy, error) {
+		return func(_ context.Context, _ *proxy.Request) (*proxy.Response, error) {
+			return &proxy.Response{}, nil
+		}, nil
+	})).New(&config.EndpointConfig{
+		Endpoint: "/foo",
+		ExtraConfig: config.ExtraConfig{
+			ProxyNamespace: map[string]interface{}{
+				"pre": `http_response.new("` + ts.URL + `")
+trace.set_attribute("tenant", "acme")
+trace.add_event("checked", {retries = 2})
+if trace.trace_id() == "" then error("no trace id") end`,
+				"post": `trace.set_attribute("cached", false)`,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, root := provider.Tracer("test").Start(context.Background(), "request")
+	if _, err := prxy(ctx, &proxy.Request{Params: map[string]string{}, Headers: map[string][]string{}}); err != nil {
+		t.Error(err)
+	}
+	root.End()
+
+	spans := map[string]tracetest.SpanStub{}
+	for _, s := range exporter.GetSpans() {
+		spans[s.Name] = s
+	}
+
+	for name, parent := range map[string]string{
+		"lua pre":      "request",
+		"lua http GET": "lua pre",
+		"lua next":     "request",
+		"lua post":     "request",
+	} {
+		s, ok := spans[name]
+		if !ok {
+			t.Errorf("span %s not found", name)
+			continue
+		}
+		if s.Parent.SpanID() != spans[parent].SpanContext.SpanID() {
+			t.Errorf("the parent of the span %s is not %s", name, parent)
+		}
+	}
+
+	if !hasAttribute(spans["lua pre"].Attributes, attribute.String("tenant", "acme")) {
+		t.Errorf("attribute not found: %v", spans["lua pre"].Attributes)
+	}
+	if !hasAttribute(spans["lua post"].Attributes, attribute.Bool("cached", false)) {
+		t.Errorf("attribute not found: %v", spans["lua post"].Attributes)
+	}
+	if events := spans["lua pre"].Events; len(events) != 1 || events[0].Name != "checked" ||
+		!hasAttribute(events[0].Attributes, attribute.Int64("retries", 2)) {
+		t.Errorf("unexpected events: %v", events)
+	}
+
+	want := "00-" + root.SpanContext().TraceID().String() + "-" + spans["lua http GET"].SpanContext.SpanID().String() + "-01"
+	if h := <-traceparent; h != want {
+		t.Errorf("unexpected traceparent header. have: %s, want: %s", h, want)
+	}
+}
+
+func hasAttribute(attrs []attribute.KeyValue, kv attribute.KeyValue) bool {
+	for _, a := range attrs {
+		if a == kv {
+			return true
+		}
+	}
+	return false
+}
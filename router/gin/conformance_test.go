@@ -0,0 +1,34 @@
+package gin
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/krakend/krakend-lua/v2/router/routertest"
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/logging"
+	"github.com/luraproject/lura/v2/proxy"
+)
+
+func TestHandlerFactory_conformance(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	RegisterDecorator(routertest.Decorator)
+
+	hf := func(_ *config.EndpointConfig, _ proxy.Proxy) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			params := map[string]string{}
+			for _, p := range c.Params {
+				params[p.Key] = p.Value
+			}
+			routertest.Echo(c.Writer, c.Request, params)
+		}
+	}
+
+	routertest.Run(t, func(extra config.ExtraConfig) http.Handler {
+		cfg := &config.EndpointConfig{Endpoint: "/some-path/:id", ExtraConfig: extra}
+		engine := gin.New()
+		engine.GET(cfg.Endpoint, HandlerFactory(logging.NoOp, hf)(cfg, proxy.NoopProxy))
+		return engine
+	})
+}
@@ -19,28 +19,30 @@ import (
 	"github.com/luraproject/lura/v2/proxy"
 	krakendgin "github.com/luraproject/lura/v2/router/gin"
 	glua "github.com/yuin/gopher-lua"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func Register(l logging.Logger, extraConfig config.ExtraConfig, engine *gin.Engine) {
 	logPrefix := "[SERVICE: Gin][Lua]"
 	cfg, err := lua.Parse(l, extraConfig, router.Namespace)
 	if err != nil {
-		if err != lua.ErrNoExtraConfig {
-			l.Debug(logPrefix, err.Error())
+		if err == lua.ErrNoExtraConfig {
+			return
 		}
+		l.Error(logPrefix, err.Error())
+		engine.Use(func(c *gin.Context) {
+			abortWithError(l, logPrefix, c)(err)
+		})
 		return
 	}
 
+	cfg.Endpoint = router.GlobalEndpoint
 	l.Debug(logPrefix, "Middleware is now ready")
 
-	engine.Use(func(c *gin.Context) {
-		if err := process(c, &cfg); err != nil {
-			l.Error(logPrefix, err.Error())
-			c.AbortWithError(http.StatusInternalServerError, err)
-			return
-		}
+	pool := newPool(l, logPrefix, &cfg)
 
-		c.Next()
+	engine.Use(func(c *gin.Context) {
+		process(c, pool, c.Next, abortWithError(l, logPrefix, c))
 	})
 }
 
@@ -51,30 +53,40 @@ func HandlerFactory(l logging.Logger, next krakendgin.HandlerFactory) krakendgin
 
 		cfg, err := lua.Parse(l, remote.ExtraConfig, router.Namespace)
 		if err != nil {
-			if err != lua.ErrNoExtraConfig {
-				l.Debug(logPrefix, err.Error())
+			if err == lua.ErrNoExtraConfig {
+				return handlerFunc
+			}
+			l.Error(logPrefix, err.Error())
+			return func(c *gin.Context) {
+				abortWithError(l, logPrefix, c)(err)
 			}
-			return handlerFunc
 		}
 
+		cfg.Endpoint = remote.Endpoint
 		l.Debug(logPrefix, "Middleware is now ready")
 
+		pool := newPool(l, logPrefix, &cfg)
+
 		return func(c *gin.Context) {
-			if err := process(c, &cfg); err != nil {
-				if errhttp, ok := err.(errHTTP); ok {
-					if e, ok := err.(errHTTPWithContentType); ok {
-						c.Writer.Header().Add("content-type", e.Encoding())
-					}
-					c.AbortWithError(errhttp.StatusCode(), err)
-					return
-				}
-				l.Error(logPrefix, err.Error())
-				c.AbortWithError(http.StatusInternalServerError, err)
-				return
-			}
+			process(c, pool, func() { handlerFunc(c) }, abortWithError(l, logPrefix, c))
+		}
+	}
+}
 
-			handlerFunc(c)
+// abortWithError aborts the request with the status code and content type of
+// the http errors raised by the scripts. Any other error is logged and aborts
+// the request with a 500
+func abortWithError(l logging.Logger, logPrefix string, c *gin.Context) func(error) {
+	return func(err error) {
+		if errhttp, ok := err.(errHTTP); ok {
+			if e, ok := err.(errHTTPWithContentType); ok {
+				c.Writer.Header().Add("content-type", e.Encoding())
+			}
+			c.AbortWithError(errhttp.StatusCode(), err)
+			return
 		}
+		l.Error(logPrefix, err.Error())
+		c.AbortWithError(http.StatusInternalServerError, err)
 	}
 }
 
@@ -98,37 +110,84 @@ func RegisterDecorator(f decorator.Decorator) {
 	localRegisterer.decorators = append(localRegisterer.decorators, f)
 }
 
-func process(c *gin.Context, cfg *lua.Config) error {
-	b := lua.NewBinderWrapper(binder.Options{
-		SkipOpenLibs:        !cfg.AllowOpenLibs,
-		IncludeGoStackTrace: true,
+func newPool(l logging.Logger, logPrefix string, cfg *lua.Config) *lua.Pool {
+	return lua.NewPool(cfg, func(vm *lua.VM) {
+		r := &GinContext{}
+		vm.Bindings = r
+
+		decorator.RegisterErrors(vm.GetBinder())
+		decorator.RegisterNil(vm.GetBinder())
+		decorator.RegisterLuaTable(vm.GetBinder())
+		decorator.RegisterLuaList(vm.GetBinder())
+		decorator.RegisterJSON(vm.GetBinder())
+		decorator.RegisterSharedCache(vm.GetBinder())
+		decorator.RegisterMetrics(vm.GetBinder())
+		decorator.RegisterLogger(vm.GetBinder(), l, logPrefix)
+		decorator.RegisterHTTPRequestFunc(vm.Context, vm.GetBinder())
+		decorator.RegisterTrace(vm.Context, vm.GetBinder())
+		for _, f := range localRegisterer.decorators {
+			f(vm.GetBinder())
+		}
+
+		registerCtxTable(r, vm.GetBinder())
 	})
-	defer b.GetBinder().Close()
-
-	decorator.RegisterErrors(b.GetBinder())
-	decorator.RegisterNil(b.GetBinder())
-	decorator.RegisterLuaTable(b.GetBinder())
-	decorator.RegisterLuaList(b.GetBinder())
-	decorator.RegisterHTTPRequest(c, b.GetBinder())
-	for _, f := range localRegisterer.decorators {
-		f(b.GetBinder())
+}
+
+// process executes the pre script and the next handlers. When the config
+// defines a post script, the response of the handlers is buffered and sent to
+// the client after executing it. The errors are reported to the abort function
+func process(c *gin.Context, pool *lua.Pool, next func(), abort func(error)) {
+	vm, err := pool.Get(c.Request.Context())
+	if err != nil {
+		abort(err)
+		return
 	}
+	defer pool.Put(vm)
+
+	r := vm.Bindings.(*GinContext)
+	defer r.bind(nil)
 
-	registerCtxTable(c, b.GetBinder())
+	vm.Bind(c.Request.Context())
+	r.bind(c)
 
-	if err := b.WithConfig(cfg); err != nil {
-		return err
+	if err := vm.Pre(); err != nil {
+		abort(err)
+		return
 	}
 
-	return b.WithCode("pre-script", cfg.PreCode)
-}
+	if !vm.HasPost() {
+		span := startNextSpan(c)
+		next()
+		span.End()
+		return
+	}
 
-func registerCtxTable(c *gin.Context, b *binder.Binder) {
-	r := &GinContext{c}
+	w := &responseWriter{ResponseWriter: c.Writer, response: router.NewResponse(c.Writer)}
+	c.Writer = w
+	span := startNextSpan(c)
+	next()
+	span.End()
+	c.Writer = w.ResponseWriter
+
+	r.response = w.response
+	if err := vm.Post(); err != nil {
+		clear(c.Writer.Header())
+		abort(err)
+		return
+	}
+	if err := w.response.Flush(c.Writer); err != nil {
+		c.Error(err)
+	}
+}
 
+func registerCtxTable(r *GinContext, b *binder.Binder) {
+	r.budget = lua.DataBudgetOf(b)
 	t := b.Table("ctx")
 
 	t.Static("load", func(c *binder.Context) error {
+		if r.Context == nil {
+			return errContextExpected
+		}
 		c.Push().Data(r, "ctx")
 		return nil
 	})
@@ -141,10 +200,26 @@ func registerCtxTable(c *gin.Context, b *binder.Binder) {
 	t.Dynamic("headers", r.requestHeaders)
 	t.Dynamic("headerList", r.headerList)
 	t.Dynamic("body", r.requestBody)
+	router.RegisterResponseMethods(t, r.budget)
 }
 
 type GinContext struct {
 	*gin.Context
+	budget   *lua.DataBudget
+	response *router.Response
+	// charged is true once the request body is charged to the budget
+	charged bool
+}
+
+func (r *GinContext) bind(c *gin.Context) {
+	r.Context = c
+	r.response = nil
+	r.charged = false
+}
+
+// LuaResponse returns the response buffered for the post script
+func (r *GinContext) LuaResponse() *router.Response {
+	return r.response
 }
 
 func (*GinContext) method(c *binder.Context) error {
@@ -310,6 +385,7 @@ func (*GinContext) requestBody(c *binder.Context) error {
 
 	if c.Top() == 2 {
 		req.Request.Body = io.NopCloser(bytes.NewBufferString(c.Arg(2).String()))
+		req.charged = false
 		return nil
 	}
 
@@ -319,6 +395,12 @@ func (*GinContext) requestBody(c *binder.Context) error {
 		req.Request.Body.Close()
 	}
 	req.Request.Body = io.NopCloser(bytes.NewBuffer(b))
+	if !req.charged {
+		req.charged = true
+		if err := req.budget.Consume(len(b)); err != nil {
+			return err
+		}
+	}
 	c.Push().String(string(b))
 
 	return nil
@@ -329,3 +411,55 @@ var (
 	errContextExpected = errors.New("ginContext expected")
 	errInvalidLuaList  = errors.New("invalid header value, must be a luaList")
 )
+
+// startNextSpan starts the span covering the execution of the next handlers,
+// making it available to them through the request context
+func startNextSpan(c *gin.Context) trace.Span {
+	ctx, span := lua.StartSpan(c.Request.Context(), "lua next")
+	c.Request = c.Request.WithContext(ctx)
+	return span
+}
+
+// responseWriter buffers the response written by the handlers, so the post
+// script can modify it
+type responseWriter struct {
+	gin.ResponseWriter
+	response *router.Response
+}
+
+// WriteHeader replaces the status until the body is written, as the gin writer does
+func (w *responseWriter) WriteHeader(code int) {
+	if code > 0 && !w.response.Written() {
+		w.response.SetStatus(code)
+	}
+}
+
+func (w *responseWriter) WriteHeaderNow() {
+	w.response.WriteHeader(w.response.StatusCode())
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	return w.response.Write(b)
+}
+
+func (w *responseWriter) WriteString(s string) (int, error) {
+	return w.response.WriteString(s)
+}
+
+func (w *responseWriter) Status() int {
+	return w.response.StatusCode()
+}
+
+func (w *responseWriter) Size() int {
+	if !w.response.Written() {
+		return -1
+	}
+	return w.response.Len()
+}
+
+func (w *responseWriter) Written() bool {
+	return w.response.Written()
+}
+
+// Flush does nothing, since the response is sent after the post script
+func (*responseWriter) Flush() {}
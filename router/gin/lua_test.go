@@ -5,9 +5,11 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/gin-gonic/gin"
+	lua "github.com/krakend/krakend-lua/v2"
 	"github.com/krakend/krakend-lua/v2/router"
 	"github.com/luraproject/lura/v2/config"
 	"github.com/luraproject/lura/v2/logging"
@@ -186,6 +188,72 @@ func TestHandlerFactory_errorHTTP(t *testing.T) {
 	}
 }
 
+func TestHandlerFactory_timeout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.EndpointConfig{
+		Endpoint: "/",
+		ExtraConfig: config.ExtraConfig{
+			router.Namespace: map[string]interface{}{
+				"timeout": "10ms",
+				"pre":     `while true do end`,
+			},
+		},
+	}
+
+	hf := func(_ *config.EndpointConfig, _ proxy.Proxy) gin.HandlerFunc {
+		return func(_ *gin.Context) {
+			t.Error("the handler shouldn't be executed")
+		}
+	}
+	handler := HandlerFactory(logging.NoOp, hf)(cfg, proxy.NoopProxy)
+
+	engine := gin.New()
+	engine.GET("/some-path/:id", handler)
+
+	req, _ := http.NewRequest("GET", "/some-path/42?id=1", http.NoBody)
+	w := httptest.NewRecorder()
+
+	engine.ServeHTTP(w, req)
+
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("unexpected status code %d", w.Code)
+		return
+	}
+}
+
+func TestHandlerFactory_bodyBudget(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.EndpointConfig{
+		Endpoint: "/",
+		ExtraConfig: config.ExtraConfig{
+			router.Namespace: map[string]interface{}{
+				"max_data_size": 15.0,
+				"pre":           `local c = ctx.load(); c:body(); c:body()`,
+				"post":          `local c = ctx.load(); c:responseBody(); c:responseBody()`,
+			},
+		},
+	}
+
+	hf := func(_ *config.EndpointConfig, _ proxy.Proxy) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			c.String(http.StatusOK, "01234567")
+		}
+	}
+	handler := HandlerFactory(logging.NoOp, hf)(cfg, proxy.NoopProxy)
+
+	engine := gin.New()
+	engine.POST("/some-path/:id", handler)
+
+	req, _ := http.NewRequest("POST", "/some-path/42", strings.NewReader("abcde"))
+	w := httptest.NewRecorder()
+
+	engine.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK || w.Body.String() != "01234567" {
+		t.Errorf("unexpected response %d: %s", w.Code, w.Body.String())
+	}
+}
+
 func TestHandlerFactory_errorHTTPWithContentType(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	cfg := &config.EndpointConfig{
@@ -224,6 +292,75 @@ func TestHandlerFactory_errorHTTPWithContentType(t *testing.T) {
 	}
 }
 
+func TestRegister_errorHTTP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for _, tc := range []struct {
+		name        string
+		pre         string
+		status      int
+		contentType string
+	}{
+		{name: "custom error", pre: `custom_error('expect me', 403)`, status: 403},
+		{name: "with content type", pre: `custom_error('expect me', 999, 'foo/bar')`, status: 999, contentType: "foo/bar"},
+		{name: "lua error", pre: `error('boom')`, status: http.StatusInternalServerError},
+		{name: "syntax error", pre: `custom_error('denied', 401) lokal x = 1`, status: http.StatusInternalServerError},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			engine := gin.New()
+			Register(logging.NoOp, config.ExtraConfig{
+				router.Namespace: map[string]interface{}{
+					"pre": tc.pre,
+				},
+			}, engine)
+			engine.GET("/some-path/:id", func(_ *gin.Context) {
+				t.Error("the handler shouldn't be executed")
+			})
+
+			req, _ := http.NewRequest("GET", "/some-path/42", http.NoBody)
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+
+			if w.Code != tc.status {
+				t.Errorf("unexpected status code %d", w.Code)
+			}
+			if h := w.Header().Get("content-type"); tc.contentType != "" && h != tc.contentType {
+				t.Errorf("unexpected content-type %s", h)
+			}
+		})
+	}
+}
+
+func TestRegister_executionMetrics(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	registry := lua.NewMetricsRegistry()
+	defer func(m lua.ExecutionMetrics) { lua.DefaultExecutionMetrics = m }(lua.DefaultExecutionMetrics)
+	lua.DefaultExecutionMetrics = lua.NewExecutionMetrics(registry)
+
+	engine := gin.New()
+	Register(logging.NoOp, config.ExtraConfig{
+		router.Namespace: map[string]interface{}{
+			"pre": `ctx.load():headers("X-Global", "yes")`,
+		},
+	}, engine)
+	engine.GET("/some-path/:id", func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetHeader("X-Global"))
+	})
+
+	req, _ := http.NewRequest("GET", "/some-path/42", http.NoBody)
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK || w.Body.String() != "yes" {
+		t.Errorf("unexpected response %d: %s", w.Code, w.Body.String())
+	}
+
+	e := &lua.MemoryExporter{}
+	registry.Export(e)
+	if m, ok := e.Get("krakend_lua_stage_duration_seconds", map[string]string{"endpoint": router.GlobalEndpoint, "stage": lua.StagePre}); !ok || m.Count != 1 {
+		t.Errorf("unexpected metrics: %+v", e.Metrics())
+	}
+}
+
 func TestHandlerFactory_luaError(t *testing.T) {
 	var luaPreErrorTestTable = []struct {
 		Name          string
@@ -0,0 +1,105 @@
+package gin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/krakend/krakend-lua/v2/router"
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/logging"
+	"github.com/luraproject/lura/v2/proxy"
+)
+
+const samplePostCode = `local c = ctx.load()
+if c:responseStatus() ~= 201 then custom_error("unexpected status: " .. c:responseStatus()) end
+if c:responseHeaders("X-Backend") ~= "foo" then custom_error("unexpected header") end
+if c:responseCookie("session") ~= "abc" then custom_error("unexpected cookie") end
+if c:responseCookie("unknown") ~= nil then custom_error("unknown cookie") end
+if c:headers("X-Fail") == "yes" then custom_error("post failed", 418) end
+
+c:responseStatus(202)
+c:responseHeaders("X-Backend", nil)
+c:responseHeaders("X-Lua", "post")
+c:responseCookie("session", nil)
+c:responseCookie("theme", "dark", {path = "/", max_age = 60, http_only = true, same_site = "lax"})
+c:responseBody(c:responseBody() .. ", modified")`
+
+func originalResponse(c *gin.Context) {
+	c.Header("X-Backend", "foo")
+	c.SetCookie("session", "abc", 0, "", "", false, false)
+	c.Status(http.StatusNotFound)
+	c.String(http.StatusCreated, "original")
+}
+
+func TestHandlerFactory_post(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.EndpointConfig{
+		Endpoint: "/",
+		ExtraConfig: config.ExtraConfig{
+			router.Namespace: map[string]interface{}{
+				"pre":  `if pcall(function() ctx.load():responseStatus() end) then custom_error("response available in pre") end`,
+				"post": samplePostCode,
+			},
+		},
+	}
+
+	hf := func(_ *config.EndpointConfig, _ proxy.Proxy) gin.HandlerFunc {
+		return originalResponse
+	}
+	engine := gin.New()
+	engine.GET("/some-path/:id", HandlerFactory(logging.NoOp, hf)(cfg, proxy.NoopProxy))
+
+	testPostResponse(t, engine)
+}
+
+func TestRegister_post(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	Register(logging.NoOp, config.ExtraConfig{
+		router.Namespace: map[string]interface{}{
+			"post": samplePostCode,
+		},
+	}, engine)
+	engine.GET("/some-path/:id", originalResponse)
+
+	req, _ := http.NewRequest("GET", "/some-path/42", http.NoBody)
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	if w.Code != http.StatusAccepted || w.Body.String() != "original, modified" {
+		t.Errorf("unexpected response %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func testPostResponse(t *testing.T, engine *gin.Engine) {
+	req, _ := http.NewRequest("GET", "/some-path/42", http.NoBody)
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	if w.Code != http.StatusAccepted {
+		t.Errorf("unexpected status code %d: %s", w.Code, w.Body.String())
+	}
+	if body := w.Body.String(); body != "original, modified" {
+		t.Errorf("unexpected body: %s", body)
+	}
+	if h := w.Header(); h.Get("X-Backend") != "" || h.Get("X-Lua") != "post" {
+		t.Errorf("unexpected headers: %v", h)
+	}
+	if cookies := w.Header().Values("Set-Cookie"); len(cookies) != 1 || cookies[0] != "theme=dark; Path=/; Max-Age=60; HttpOnly; SameSite=Lax" {
+		t.Errorf("unexpected cookies: %v", cookies)
+	}
+
+	req, _ = http.NewRequest("GET", "/some-path/42", http.NoBody)
+	req.Header.Set("X-Fail", "yes")
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	if w.Code != http.StatusTeapot {
+		t.Errorf("unexpected response %d: %s", w.Code, w.Body.String())
+	}
+	if h := w.Header(); h.Get("X-Backend") != "" || len(h.Values("Set-Cookie")) != 0 {
+		t.Errorf("unexpected headers: %v", h)
+	}
+}
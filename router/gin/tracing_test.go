@@ -0,0 +1,72 @@
+package gin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/krakend/krakend-lua/v2/router"
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/logging"
+	"github.com/luraproject/lura/v2/proxy"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestHandlerFactory_tracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	gin.SetMode(gin.TestMode)
+	cfg := &config.EndpointConfig{
+		Endpoint: "/some-path/:id",
+		ExtraConfig: config.ExtraConfig{
+			router.Namespace: map[string]interface{}{
+				"pre": `trace.set_attribute("id", ctx.load():params("id"))`,
+			},
+		},
+	}
+
+	var next trace.SpanContext
+	hf := func(_ *config.EndpointConfig, _ proxy.Proxy) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			next = trace.SpanContextFromContext(c.Request.Context())
+		}
+	}
+	handler := HandlerFactory(logging.NoOp, hf)(cfg, proxy.NoopProxy)
+
+	engine := gin.New()
+	engine.GET("/some-path/:id", func(c *gin.Context) {
+		ctx, span := provider.Tracer("test").Start(c.Request.Context(), "request")
+		c.Request = c.Request.WithContext(ctx)
+		handler(c)
+		span.End()
+	})
+
+	req, _ := http.NewRequest("GET", "/some-path/42", http.NoBody)
+	engine.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := map[string]tracetest.SpanStub{}
+	for _, s := range exporter.GetSpans() {
+		spans[s.Name] = s
+	}
+
+	root := spans["request"].SpanContext
+	for _, name := range []string{"lua pre", "lua next"} {
+		if s, ok := spans[name]; !ok || s.Parent.SpanID() != root.SpanID() {
+			t.Errorf("the span %s is not a child of the request span", name)
+		}
+	}
+	if next.SpanID() != spans["lua next"].SpanContext.SpanID() {
+		t.Error("the next handler did not get the span")
+	}
+	if attrs := spans["lua pre"].Attributes; len(attrs) == 0 || attrs[len(attrs)-1].Value.AsString() != "42" {
+		t.Errorf("unexpected attributes: %v", attrs)
+	}
+}
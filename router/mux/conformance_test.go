@@ -0,0 +1,39 @@
+package mux
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"testing"
+
+	"github.com/krakend/krakend-lua/v2/router/routertest"
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/logging"
+	"github.com/luraproject/lura/v2/proxy"
+)
+
+type routeParamsKey struct{}
+
+func TestHandlerFactory_conformance(t *testing.T) {
+	RegisterDecorator(routertest.Decorator)
+
+	// the params are resolved when routing, like the ones of the mux engines
+	pe := func(r *http.Request) map[string]string {
+		params, _ := r.Context().Value(routeParamsKey{}).(map[string]string)
+		return params
+	}
+	hf := func(_ *config.EndpointConfig, _ proxy.Proxy) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			routertest.Echo(w, r, ParamExtractor(pe)(r))
+		}
+	}
+
+	routertest.Run(t, func(extra config.ExtraConfig) http.Handler {
+		cfg := &config.EndpointConfig{Endpoint: "/some-path/{id}", ExtraConfig: extra}
+		handler := HandlerFactory(logging.NoOp, hf, pe)(cfg, proxy.NoopProxy)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			params := map[string]string{"id": path.Base(r.URL.Path)}
+			handler(w, r.WithContext(context.WithValue(r.Context(), routeParamsKey{}, params)))
+		})
+	})
+}
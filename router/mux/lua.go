@@ -2,6 +2,7 @@ package mux
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -24,30 +25,42 @@ func RegisterMiddleware(l logging.Logger, e config.ExtraConfig, pe mux.ParamExtr
 	logPrefix := "[Service: Mux][Lua]"
 	cfg, err := lua.Parse(l, e, router.Namespace)
 	if err != nil {
-		if err != lua.ErrNoExtraConfig {
-			l.Debug(logPrefix, err.Error())
+		if err == lua.ErrNoExtraConfig {
+			return mws
 		}
-		return mws
+		l.Error(logPrefix, err.Error())
+		return append(mws, &failingMiddleware{err: err, l: l, logPrefix: logPrefix})
 	}
 
+	cfg.Endpoint = router.GlobalEndpoint
 	l.Debug(logPrefix, "Middleware is now ready")
 
-	return append(mws, &middleware{pe: pe, cfg: cfg})
+	return append(mws, &middleware{pool: newPool(l, logPrefix, &cfg, pe), l: l, logPrefix: logPrefix})
 }
 
 type middleware struct {
-	pe  mux.ParamExtractor
-	cfg lua.Config
+	pool      *lua.Pool
+	l         logging.Logger
+	logPrefix string
 }
 
 func (hm *middleware) Handler(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if err := process(r, hm.pe, &hm.cfg); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+		process(w, r, hm.pool, h.ServeHTTP, writeError(hm.l, hm.logPrefix, w))
+	})
+}
+
+// failingMiddleware rejects all the requests with the error of a lua config
+// that can not be executed, so the scripts are never skipped
+type failingMiddleware struct {
+	err       error
+	l         logging.Logger
+	logPrefix string
+}
 
-		h.ServeHTTP(w, r)
+func (fm *failingMiddleware) Handler(_ http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		writeError(fm.l, fm.logPrefix, w)(fm.err)
 	})
 }
 
@@ -58,31 +71,42 @@ func HandlerFactory(l logging.Logger, next mux.HandlerFactory, pe mux.ParamExtra
 
 		cfg, err := lua.Parse(l, remote.ExtraConfig, router.Namespace)
 		if err != nil {
-			if err != lua.ErrNoExtraConfig {
-				l.Debug(logPrefix, err.Error())
+			if err == lua.ErrNoExtraConfig {
+				return handlerFunc
+			}
+			l.Error(logPrefix, err.Error())
+			return func(w http.ResponseWriter, _ *http.Request) {
+				writeError(l, logPrefix, w)(err)
 			}
-			return handlerFunc
 		}
 
+		cfg.Endpoint = remote.Endpoint
 		l.Debug(logPrefix, "Middleware is now ready")
 
+		pool := newPool(l, logPrefix, &cfg, pe)
+
 		return func(w http.ResponseWriter, r *http.Request) {
-			if err := process(r, pe, &cfg); err != nil {
-				if errhttp, ok := err.(errHTTP); ok {
-					if e, ok := err.(errHTTPWithContentType); ok {
-						w.Header().Add("content-type", e.Encoding())
-					}
-					w.WriteHeader(errhttp.StatusCode())
-					w.Write([]byte(err.Error()))
-					return
-				}
-
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
+			process(w, r, pool, handlerFunc, writeError(l, logPrefix, w))
+		}
+	}
+}
 
-			handlerFunc(w, r)
+// writeError responds with the status code and content type of the http
+// errors raised by the scripts. Any other error is logged and responded
+// with a 500
+func writeError(l logging.Logger, logPrefix string, w http.ResponseWriter) func(error) {
+	return func(err error) {
+		if errhttp, ok := err.(errHTTP); ok {
+			if e, ok := err.(errHTTPWithContentType); ok {
+				w.Header().Add("content-type", e.Encoding())
+			}
+			w.WriteHeader(errhttp.StatusCode())
+			w.Write([]byte(err.Error()))
+			return
 		}
+
+		l.Error(logPrefix, err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
 
@@ -96,51 +120,127 @@ type errHTTPWithContentType interface {
 	Encoding() string
 }
 
-func process(r *http.Request, pe mux.ParamExtractor, cfg *lua.Config) error {
-	b := lua.NewBinderWrapper(binder.Options{
-		SkipOpenLibs:        !cfg.AllowOpenLibs,
-		IncludeGoStackTrace: true,
+type registerer struct {
+	decorators []decorator.Decorator
+}
+
+var localRegisterer = registerer{decorators: []decorator.Decorator{}}
+
+func RegisterDecorator(f decorator.Decorator) {
+	localRegisterer.decorators = append(localRegisterer.decorators, f)
+}
+
+func newPool(l logging.Logger, logPrefix string, cfg *lua.Config, pe mux.ParamExtractor) *lua.Pool {
+	return lua.NewPool(cfg, func(vm *lua.VM) {
+		mctx := &muxContext{pe: pe}
+		vm.Bindings = mctx
+
+		decorator.RegisterErrors(vm.GetBinder())
+		decorator.RegisterNil(vm.GetBinder())
+		decorator.RegisterLuaTable(vm.GetBinder())
+		decorator.RegisterLuaList(vm.GetBinder())
+		decorator.RegisterJSON(vm.GetBinder())
+		decorator.RegisterSharedCache(vm.GetBinder())
+		decorator.RegisterMetrics(vm.GetBinder())
+		decorator.RegisterLogger(vm.GetBinder(), l, logPrefix)
+		decorator.RegisterHTTPRequestFunc(vm.Context, vm.GetBinder())
+		decorator.RegisterTrace(vm.Context, vm.GetBinder())
+		for _, f := range localRegisterer.decorators {
+			f(vm.GetBinder())
+		}
+
+		registerRequestTable(mctx, vm.GetBinder())
 	})
+}
+
+// process executes the pre script and the next handler. When the config
+// defines a post script, the response of the handler is buffered and sent to
+// the client after executing it. The errors are reported to the abort function
+func process(w http.ResponseWriter, r *http.Request, pool *lua.Pool, next http.HandlerFunc, abort func(error)) {
+	vm, err := pool.Get(r.Context())
+	if err != nil {
+		abort(err)
+		return
+	}
+	defer pool.Put(vm)
+
+	mctx := vm.Bindings.(*muxContext)
+	defer mctx.bind(nil)
 
-	decorator.RegisterErrors(b.GetBinder())
-	decorator.RegisterNil(b.GetBinder())
-	decorator.RegisterLuaTable(b.GetBinder())
-	decorator.RegisterLuaList(b.GetBinder())
-	decorator.RegisterHTTPRequest(r.Context(), b.GetBinder())
-	registerRequestTable(r, pe, b.GetBinder())
+	vm.Bind(r.Context())
+	mctx.bind(r)
 
-	if err := b.WithConfig(cfg); err != nil {
-		return err
+	if err := vm.Pre(); err != nil {
+		abort(err)
+		return
 	}
 
-	return b.WithCode("pre-script", cfg.PreCode)
-}
+	ctx, span := lua.StartSpan(r.Context(), "lua next")
+	if len(mctx.overrides) > 0 {
+		ctx = context.WithValue(ctx, paramsKey{}, mctx.overrides)
+	}
+	if !vm.HasPost() {
+		next(w, r.WithContext(ctx))
+		span.End()
+		return
+	}
+
+	resp := router.NewResponse(w)
+	next(resp, r.WithContext(ctx))
+	span.End()
 
-func registerRequestTable(r *http.Request, pe mux.ParamExtractor, b *binder.Binder) {
-	mctx := &muxContext{
-		Request: r,
-		pe:      pe,
+	mctx.response = resp
+	if err := vm.Post(); err != nil {
+		clear(w.Header())
+		abort(err)
+		return
 	}
+	resp.Flush(w)
+}
 
+func registerRequestTable(mctx *muxContext, b *binder.Binder) {
+	mctx.budget = lua.DataBudgetOf(b)
 	t := b.Table("ctx")
 
 	t.Static("load", func(c *binder.Context) error {
+		if mctx.Request == nil {
+			return errContextExpected
+		}
 		c.Push().Data(mctx, "ctx")
 		return nil
 	})
 
 	t.Dynamic("method", mctx.method)
 	t.Dynamic("url", mctx.url)
+	t.Dynamic("host", mctx.host)
 	t.Dynamic("query", mctx.query)
 	t.Dynamic("params", mctx.params)
 	t.Dynamic("headers", mctx.headers)
 	t.Dynamic("headerList", mctx.headerList)
 	t.Dynamic("body", mctx.body)
+	router.RegisterResponseMethods(t, mctx.budget)
 }
 
 type muxContext struct {
 	*http.Request
-	pe mux.ParamExtractor
+	pe        mux.ParamExtractor
+	budget    *lua.DataBudget
+	response  *router.Response
+	overrides map[string]string
+	// charged is true once the request body is charged to the budget
+	charged bool
+}
+
+func (mctx *muxContext) bind(r *http.Request) {
+	mctx.Request = r
+	mctx.response = nil
+	mctx.overrides = nil
+	mctx.charged = false
+}
+
+// LuaResponse returns the response buffered for the post script
+func (mctx *muxContext) LuaResponse() *router.Response {
+	return mctx.response
 }
 
 func (*muxContext) method(c *binder.Context) error {
@@ -173,6 +273,21 @@ func (*muxContext) url(c *binder.Context) error {
 	return nil
 }
 
+func (*muxContext) host(c *binder.Context) error {
+	req, ok := c.Arg(1).Data().(*muxContext)
+	if !ok {
+		return errContextExpected
+	}
+
+	if c.Top() == 1 {
+		c.Push().String(req.Host)
+	} else {
+		req.Host = c.Arg(2).String()
+	}
+
+	return nil
+}
+
 func (*muxContext) query(c *binder.Context) error {
 	req, ok := c.Arg(1).Data().(*muxContext)
 	if !ok {
@@ -193,10 +308,67 @@ func (*muxContext) query(c *binder.Context) error {
 	return nil
 }
 
-func (*muxContext) params(_ *binder.Context) error {
+func (*muxContext) params(c *binder.Context) error {
+	req, ok := c.Arg(1).Data().(*muxContext)
+	if !ok {
+		return errContextExpected
+	}
+	switch c.Top() {
+	case 1:
+		params := map[string]string{}
+		if req.pe != nil {
+			for k, v := range req.pe(req.Request) {
+				params[k] = v
+			}
+		}
+		for k, v := range req.overrides {
+			params[k] = v
+		}
+		c.Push().Data(lua.NewTableFromStringMap(params), "luaTable")
+	case 2:
+		key := c.Arg(2).String()
+		if v, ok := req.overrides[key]; ok {
+			c.Push().String(v)
+			return nil
+		}
+		if req.pe == nil {
+			c.Push().String("")
+			return nil
+		}
+		c.Push().String(req.pe(req.Request)[key])
+	case 3:
+		if req.overrides == nil {
+			req.overrides = map[string]string{}
+		}
+		req.overrides[c.Arg(2).String()] = c.Arg(3).String()
+	}
+
 	return nil
 }
 
+type paramsKey struct{}
+
+// ParamExtractor returns an extractor adding the params set by the scripts to
+// the ones of the given extractor. The handlers must use it in order to see the
+// params modified by the scripts
+func ParamExtractor(pe mux.ParamExtractor) mux.ParamExtractor {
+	return func(r *http.Request) map[string]string {
+		params := pe(r)
+		overrides, ok := r.Context().Value(paramsKey{}).(map[string]string)
+		if !ok {
+			return params
+		}
+		res := make(map[string]string, len(params)+len(overrides))
+		for k, v := range params {
+			res[k] = v
+		}
+		for k, v := range overrides {
+			res[k] = v
+		}
+		return res
+	}
+}
+
 func (*muxContext) headers(c *binder.Context) error {
 	req, ok := c.Arg(1).Data().(*muxContext)
 	if !ok {
@@ -267,6 +439,7 @@ func (*muxContext) body(c *binder.Context) error {
 
 	if c.Top() == 2 {
 		req.Body = io.NopCloser(bytes.NewBufferString(c.Arg(2).String()))
+		req.charged = false
 		return nil
 	}
 
@@ -276,6 +449,12 @@ func (*muxContext) body(c *binder.Context) error {
 		req.Body.Close()
 	}
 	req.Body = io.NopCloser(bytes.NewBuffer(b))
+	if !req.charged {
+		req.charged = true
+		if err := req.budget.Consume(len(b)); err != nil {
+			return err
+		}
+	}
 	c.Push().String(string(b))
 
 	return nil
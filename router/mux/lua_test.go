@@ -8,6 +8,7 @@ import (
 	"strings"
 	"testing"
 
+	lua "github.com/krakend/krakend-lua/v2"
 	"github.com/krakend/krakend-lua/v2/router"
 	"github.com/luraproject/lura/v2/config"
 	"github.com/luraproject/lura/v2/logging"
@@ -33,7 +34,11 @@ func TestHandlerFactory(t *testing.T) {
 
 				"pre": `local req = ctx.load()
 		req:method("POST")
+		if req:params("id") ~= "42" then custom_error("unexpected id param") end
 		req:params("foo", "some_new_value")
+		if req:params("foo") ~= "some_new_value" then custom_error("unexpected foo param") end
+		local params = req:params()
+		if params:get("id") ~= "42" or params:get("foo") ~= "some_new_value" then custom_error("unexpected params") end
 		req:headers("Accept", "application/xml")
 		req:headers("X-To-Delete", nil)
 		req:headers("X-TO-DELETE-LOWER", nil)
@@ -53,8 +58,13 @@ func TestHandlerFactory(t *testing.T) {
 		},
 	}
 
+	pe := func(_ *http.Request) map[string]string {
+		return map[string]string{"id": "42"}
+	}
+
 	hf := func(_ *config.EndpointConfig, _ proxy.Proxy) http.HandlerFunc {
 		return func(_ http.ResponseWriter, r *http.Request) {
+			params := ParamExtractor(pe)(r)
 			if URL := r.URL.String(); URL != "/some-path/42?extra=foo&id=1&more=true" {
 				t.Errorf("unexpected URL: %s", URL)
 			}
@@ -79,12 +89,12 @@ func TestHandlerFactory(t *testing.T) {
 			if e := r.URL.Query().Get("extra"); e != "foo" {
 				t.Errorf("unexpected querystring extra: '%s' %v", e, r.URL.Query())
 			}
-			// if foo := c.Param("foo"); foo != "some_new_value" {
-			// 	t.Errorf("unexpected param foo: %s", foo)
-			// }
-			// if id := c.Param("id"); id != "42" {
-			// 	t.Errorf("unexpected param id: %s", id)
-			// }
+			if foo := params["foo"]; foo != "some_new_value" {
+				t.Errorf("unexpected param foo: %s", foo)
+			}
+			if id := params["id"]; id != "42" {
+				t.Errorf("unexpected param id: %s", id)
+			}
 			b, err := io.ReadAll(r.Body)
 			if err != nil {
 				t.Error(err)
@@ -95,9 +105,7 @@ func TestHandlerFactory(t *testing.T) {
 			}
 		}
 	}
-	handler := HandlerFactory(logging.NoOp, hf, func(_ *http.Request) map[string]string {
-		return map[string]string{}
-	})(cfg, proxy.NoopProxy)
+	handler := HandlerFactory(logging.NoOp, hf, pe)(cfg, proxy.NoopProxy)
 
 	req, _ := http.NewRequest("GET", "/some-path/42?id=1", http.NoBody)
 	req.Header.Set("Accept", "application/json")
@@ -175,6 +183,68 @@ func TestHandlerFactory_errorHTTP(t *testing.T) {
 	}
 }
 
+func TestHandlerFactory_timeout(t *testing.T) {
+	cfg := &config.EndpointConfig{
+		Endpoint: "/",
+		ExtraConfig: config.ExtraConfig{
+			router.Namespace: map[string]interface{}{
+				"timeout": "10ms",
+				"pre":     `while true do end`,
+			},
+		},
+	}
+
+	hf := func(_ *config.EndpointConfig, _ proxy.Proxy) http.HandlerFunc {
+		return func(_ http.ResponseWriter, _ *http.Request) {
+			t.Error("the handler shouldn't be executed")
+		}
+	}
+	handler := HandlerFactory(logging.NoOp, hf, func(_ *http.Request) map[string]string {
+		return map[string]string{}
+	})(cfg, proxy.NoopProxy)
+
+	req, _ := http.NewRequest("GET", "/some-path/42?id=1", http.NoBody)
+	w := httptest.NewRecorder()
+
+	handler(w, req)
+
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("unexpected status code %d", w.Code)
+		return
+	}
+}
+
+func TestHandlerFactory_bodyBudget(t *testing.T) {
+	cfg := &config.EndpointConfig{
+		Endpoint: "/",
+		ExtraConfig: config.ExtraConfig{
+			router.Namespace: map[string]interface{}{
+				"max_data_size": 15.0,
+				"pre":           `local c = ctx.load(); c:body(); c:body()`,
+				"post":          `local c = ctx.load(); c:responseBody(); c:responseBody()`,
+			},
+		},
+	}
+
+	hf := func(_ *config.EndpointConfig, _ proxy.Proxy) http.HandlerFunc {
+		return func(w http.ResponseWriter, _ *http.Request) {
+			w.Write([]byte("01234567"))
+		}
+	}
+	handler := HandlerFactory(logging.NoOp, hf, func(_ *http.Request) map[string]string {
+		return map[string]string{}
+	})(cfg, proxy.NoopProxy)
+
+	req, _ := http.NewRequest("POST", "/some-path/42", strings.NewReader("abcde"))
+	w := httptest.NewRecorder()
+
+	handler(w, req)
+
+	if w.Code != http.StatusOK || w.Body.String() != "01234567" {
+		t.Errorf("unexpected response %d: %s", w.Code, w.Body.String())
+	}
+}
+
 func TestHandlerFactory_errorHTTPWithContentType(t *testing.T) {
 	cfg := &config.EndpointConfig{
 		Endpoint: "/",
@@ -211,6 +281,81 @@ func TestHandlerFactory_errorHTTPWithContentType(t *testing.T) {
 	}
 }
 
+func TestRegisterMiddleware_errorHTTP(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		pre         string
+		status      int
+		contentType string
+	}{
+		{name: "custom error", pre: `custom_error('expect me', 403)`, status: 403},
+		{name: "with content type", pre: `custom_error('expect me', 999, 'foo/bar')`, status: 999, contentType: "foo/bar"},
+		{name: "lua error", pre: `error('boom')`, status: http.StatusInternalServerError, contentType: "text/plain; charset=utf-8"},
+		{name: "syntax error", pre: `custom_error('denied', 401) lokal x = 1`, status: http.StatusInternalServerError, contentType: "text/plain; charset=utf-8"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mws := RegisterMiddleware(logging.NoOp, config.ExtraConfig{
+				router.Namespace: map[string]interface{}{
+					"pre": tc.pre,
+				},
+			}, func(_ *http.Request) map[string]string {
+				return map[string]string{}
+			}, nil)
+			if len(mws) != 1 {
+				t.Fatalf("unexpected middlewares: %d", len(mws))
+			}
+			handler := mws[0].Handler(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+				t.Error("the handler shouldn't be executed")
+			}))
+
+			req, _ := http.NewRequest("GET", "/some-path/42", http.NoBody)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tc.status {
+				t.Errorf("unexpected status code %d", w.Code)
+			}
+			if h := w.Header().Get("content-type"); tc.contentType != "" && h != tc.contentType {
+				t.Errorf("unexpected content-type %s", h)
+			}
+		})
+	}
+}
+
+func TestRegisterMiddleware_executionMetrics(t *testing.T) {
+	registry := lua.NewMetricsRegistry()
+	defer func(m lua.ExecutionMetrics) { lua.DefaultExecutionMetrics = m }(lua.DefaultExecutionMetrics)
+	lua.DefaultExecutionMetrics = lua.NewExecutionMetrics(registry)
+
+	mws := RegisterMiddleware(logging.NoOp, config.ExtraConfig{
+		router.Namespace: map[string]interface{}{
+			"pre": `ctx.load():headers("X-Global", "yes")`,
+		},
+	}, func(_ *http.Request) map[string]string {
+		return map[string]string{}
+	}, nil)
+	if len(mws) != 1 {
+		t.Fatalf("unexpected middlewares: %d", len(mws))
+	}
+	handler := mws[0].Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("X-Global")))
+	}))
+
+	req, _ := http.NewRequest("GET", "/some-path/42", http.NoBody)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK || w.Body.String() != "yes" {
+		t.Errorf("unexpected response %d: %s", w.Code, w.Body.String())
+	}
+
+	e := &lua.MemoryExporter{}
+	registry.Export(e)
+	if m, ok := e.Get("krakend_lua_stage_duration_seconds", map[string]string{"endpoint": router.GlobalEndpoint, "stage": lua.StagePre}); !ok || m.Count != 1 {
+		t.Errorf("unexpected metrics: %+v", e.Metrics())
+	}
+}
+
 func TestHandlerFactory_luaError(t *testing.T) {
 	var luaPreErrorTestTable = []struct {
 		Name          string
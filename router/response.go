@@ -0,0 +1,254 @@
+package router
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/krakend/binder"
+	lua "github.com/krakend/krakend-lua/v2"
+	glua "github.com/yuin/gopher-lua"
+)
+
+var (
+	errNeedsArguments  = errors.New("need arguments")
+	errNoResponse      = errors.New("the response is only available in the post script")
+	errWrongCookieOpts = errors.New("cookie options expected: a table")
+)
+
+// Response buffers the response written by the handlers, so the post scripts
+// can modify it before it is sent to the client. It shares the header map with
+// the wrapped writer.
+type Response struct {
+	header  http.Header
+	status  int
+	written bool
+	body    bytes.Buffer
+	// charged is true once the body is charged to the budget
+	charged bool
+}
+
+// NewResponse returns an empty response using the headers of the writer
+func NewResponse(w http.ResponseWriter) *Response {
+	return &Response{header: w.Header(), status: http.StatusOK}
+}
+
+func (r *Response) Header() http.Header {
+	return r.header
+}
+
+// WriteHeader keeps the first status code, as the http.ResponseWriter does
+func (r *Response) WriteHeader(code int) {
+	if r.written {
+		return
+	}
+	r.status = code
+	r.written = true
+}
+
+// SetStatus replaces the status code
+func (r *Response) SetStatus(code int) {
+	r.status = code
+}
+
+func (r *Response) Write(b []byte) (int, error) {
+	r.written = true
+	return r.body.Write(b)
+}
+
+func (r *Response) WriteString(s string) (int, error) {
+	r.written = true
+	return r.body.WriteString(s)
+}
+
+// Written returns true if the handlers wrote the status or the body
+func (r *Response) Written() bool {
+	return r.written
+}
+
+func (r *Response) StatusCode() int {
+	return r.status
+}
+
+func (r *Response) Len() int {
+	return r.body.Len()
+}
+
+// Flush sends the buffered response to the writer
+func (r *Response) Flush(w http.ResponseWriter) error {
+	if r.header.Get("Content-Length") != "" {
+		r.header.Set("Content-Length", strconv.Itoa(r.body.Len()))
+	}
+	w.WriteHeader(r.status)
+	_, err := w.Write(r.body.Bytes())
+	return err
+}
+
+// ResponseHolder is implemented by the ctx of the routers, giving access to
+// the buffered response during the post script
+type ResponseHolder interface {
+	LuaResponse() *Response
+}
+
+// RegisterResponseMethods adds the methods managing the response to the ctx table
+func RegisterResponseMethods(t *binder.Table, budget *lua.DataBudget) {
+	t.Dynamic("responseStatus", responseStatus)
+	t.Dynamic("responseHeaders", responseHeaders)
+	t.Dynamic("responseBody", responseBody(budget))
+	t.Dynamic("responseCookie", responseCookie)
+}
+
+func responseOf(c *binder.Context) (*Response, error) {
+	h, ok := c.Arg(1).Data().(ResponseHolder)
+	if !ok {
+		return nil, errNoResponse
+	}
+	resp := h.LuaResponse()
+	if resp == nil {
+		return nil, errNoResponse
+	}
+	return resp, nil
+}
+
+func responseStatus(c *binder.Context) error {
+	resp, err := responseOf(c)
+	if err != nil {
+		return err
+	}
+	if c.Top() == 1 {
+		c.Push().Number(float64(resp.status))
+		return nil
+	}
+	resp.SetStatus(int(c.Arg(2).Number()))
+	return nil
+}
+
+func responseHeaders(c *binder.Context) error {
+	resp, err := responseOf(c)
+	if err != nil {
+		return err
+	}
+	switch c.Top() {
+	case 1:
+		c.Push().Data(lua.NewTableFromStringSliceMap(resp.header), "luaTable")
+	case 2:
+		c.Push().String(resp.header.Get(c.Arg(2).String()))
+	case 3:
+		if _, isNil := c.Arg(3).Any().(*glua.LNilType); isNil {
+			resp.header.Del(c.Arg(2).String())
+			return nil
+		}
+		resp.header.Set(c.Arg(2).String(), c.Arg(3).String())
+	}
+	return nil
+}
+
+func responseBody(budget *lua.DataBudget) func(*binder.Context) error {
+	return func(c *binder.Context) error {
+		resp, err := responseOf(c)
+		if err != nil {
+			return err
+		}
+		if c.Top() == 2 {
+			resp.body.Reset()
+			resp.body.WriteString(c.Arg(2).String())
+			resp.charged = false
+			return nil
+		}
+		if !resp.charged {
+			resp.charged = true
+			if err := budget.Consume(resp.body.Len()); err != nil {
+				return err
+			}
+		}
+		c.Push().String(resp.body.String())
+		return nil
+	}
+}
+
+// responseCookie returns the value of the cookie set by the response, sets a
+// cookie with the optional path, domain, max_age, secure, http_only and
+// same_site options, or removes it when the value is nil
+func responseCookie(c *binder.Context) error {
+	resp, err := responseOf(c)
+	if err != nil {
+		return err
+	}
+	if c.Top() < 2 {
+		return errNeedsArguments
+	}
+	name := c.Arg(2).String()
+
+	if c.Top() == 2 {
+		for _, cookie := range (&http.Response{Header: resp.header}).Cookies() {
+			if cookie.Name == name {
+				c.Push().String(cookie.Value)
+				return nil
+			}
+		}
+		lua.PushNil(c)
+		return nil
+	}
+
+	removeCookie(resp.header, name)
+	if _, isNil := c.Arg(3).Any().(*glua.LNilType); isNil {
+		return nil
+	}
+
+	cookie := &http.Cookie{Name: name, Value: c.Arg(3).String()}
+	if c.Top() > 3 {
+		opts, ok := c.Arg(4).Any().(*lua.NativeTable)
+		if !ok {
+			return errWrongCookieOpts
+		}
+		cookieOptions(cookie, opts)
+	}
+	if err := cookie.Valid(); err != nil {
+		return err
+	}
+	resp.header.Add("Set-Cookie", cookie.String())
+	return nil
+}
+
+func cookieOptions(cookie *http.Cookie, opts *lua.NativeTable) {
+	if v, ok := opts.RawGetString("path").(lua.NativeString); ok {
+		cookie.Path = string(v)
+	}
+	if v, ok := opts.RawGetString("domain").(lua.NativeString); ok {
+		cookie.Domain = string(v)
+	}
+	if v, ok := opts.RawGetString("max_age").(lua.NativeNumber); ok {
+		cookie.MaxAge = int(v)
+	}
+	if v, ok := opts.RawGetString("secure").(lua.NativeBool); ok {
+		cookie.Secure = bool(v)
+	}
+	if v, ok := opts.RawGetString("http_only").(lua.NativeBool); ok {
+		cookie.HttpOnly = bool(v)
+	}
+	switch v, _ := opts.RawGetString("same_site").(lua.NativeString); string(v) {
+	case "lax":
+		cookie.SameSite = http.SameSiteLaxMode
+	case "strict":
+		cookie.SameSite = http.SameSiteStrictMode
+	case "none":
+		cookie.SameSite = http.SameSiteNoneMode
+	}
+}
+
+func removeCookie(h http.Header, name string) {
+	cookies := h.Values("Set-Cookie")
+	kept := cookies[:0:0]
+	for _, v := range cookies {
+		parsed, err := http.ParseSetCookie(v)
+		if err == nil && parsed.Name == name {
+			continue
+		}
+		kept = append(kept, v)
+	}
+	h.Del("Set-Cookie")
+	for _, v := range kept {
+		h.Add("Set-Cookie", v)
+	}
+}
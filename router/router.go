@@ -1,3 +1,7 @@
 package router
 
 const Namespace = "github.com/devopsfaith/krakend-lua/router"
+
+// GlobalEndpoint is the endpoint of the metrics and spans of the scripts
+// executed by the global middlewares of the routers
+const GlobalEndpoint = "global"
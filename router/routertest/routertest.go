@@ -0,0 +1,271 @@
+// Package routertest contains a conformance suite executing the same scripts
+// against the lua integrations of the routers, so they keep the same behavior.
+package routertest
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/krakend/binder"
+	"github.com/krakend/krakend-lua/v2/router"
+	"github.com/luraproject/lura/v2/config"
+)
+
+// Path is the path requested by the suite. The routers must serve it with
+// the endpoint returned by the Builder, exposing the last segment as the id
+// param
+const Path = "/some-path/42"
+
+// EchoHeader is the header added by Echo to its responses
+const EchoHeader = "X-Echo"
+
+// Builder returns the handler of a router serving Path with the lua
+// middleware configured with the extra config. The endpoint behind the
+// middleware must respond with Echo.
+type Builder func(extra config.ExtraConfig) http.Handler
+
+// EchoResponse is the description of the request received by the endpoint
+type EchoResponse struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Host    string            `json:"host"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+	Params  map[string]string `json:"params"`
+}
+
+// Echo responds with the description of the request and its params
+func Echo(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	b, _ := io.ReadAll(r.Body)
+	res := EchoResponse{
+		Method:  r.Method,
+		URL:     r.URL.RequestURI(),
+		Host:    r.Host,
+		Headers: map[string]string{},
+		Body:    string(b),
+		Params:  params,
+	}
+	for k := range r.Header {
+		res.Headers[k] = r.Header.Get(k)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(EchoHeader, "true")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(res)
+}
+
+// Decorator registers the conformance table, so the suite can check the
+// decorators registered by the users are available for the scripts
+func Decorator(b *binder.Binder) {
+	t := b.Table("conformance")
+	t.Static("answer", func(c *binder.Context) error {
+		c.Push().String("42")
+		return nil
+	})
+}
+
+type testCase struct {
+	name        string
+	pre         string
+	post        string
+	status      int
+	contentType string
+	// headers of the response, empty values are expected to be missing
+	headers map[string]string
+	// echo is the request expected by the endpoint, if its response is not
+	// replaced by the script
+	echo *EchoResponse
+	// body of the response, checked when not empty
+	body string
+}
+
+var cases = []testCase{
+	{
+		name: "request",
+		pre: `local req = ctx.load()
+if req:method() ~= "GET" then custom_error("unexpected method: " .. req:method()) end
+if req:host() ~= "localhost" then custom_error("unexpected host: " .. req:host()) end
+if req:params("id") ~= "42" then custom_error("unexpected id param") end
+if req:query("id") ~= "1" then custom_error("unexpected id query") end
+if req:headers("Accept") ~= "application/json" then custom_error("unexpected accept header") end
+
+req:method("POST")
+req:host("example.com")
+req:url(req:url() .. "&more=true")
+req:query("extra", "foo")
+req:params("foo", "bar")
+req:headers("Accept", "application/xml")
+req:headers("X-To-Delete", nil)
+req:headers("X-Answer", conformance.answer())
+req:body(req:body() .. "-modified")`,
+		status:      http.StatusOK,
+		contentType: "application/json",
+		echo: &EchoResponse{
+			Method: "POST",
+			URL:    "/some-path/42?extra=foo&id=1&more=true",
+			Host:   "example.com",
+			Headers: map[string]string{
+				"Accept":      "application/xml",
+				"X-To-Delete": "",
+				"X-Answer":    "42",
+			},
+			Body:   "original-modified",
+			Params: map[string]string{"id": "42", "foo": "bar"},
+		},
+	},
+	{
+		name: "helpers",
+		pre: `local req = ctx.load()
+local t = luaTable.new()
+t:set("a", 1)
+local l = luaList.new()
+l:set(0, "x")
+l:set(1, "y")
+req:headerList("X-Multi", l)
+req:headers("X-Json", json.encode(t))
+local all = req:headers()
+if all:get("Accept") == nil then custom_error("missing headers") end
+req:headers("X-Params", tostring(req:params():len()))`,
+		status:      http.StatusOK,
+		contentType: "application/json",
+		echo: &EchoResponse{
+			Method: "GET",
+			URL:    "/some-path/42?id=1",
+			Host:   "localhost",
+			Headers: map[string]string{
+				"X-Multi":  "x",
+				"X-Json":   `{"a":1}`,
+				"X-Params": "1",
+			},
+			Body:   "original",
+			Params: map[string]string{"id": "42"},
+		},
+	},
+	{
+		name:    "custom error",
+		pre:     `custom_error("denied", 403)`,
+		status:  http.StatusForbidden,
+		headers: map[string]string{EchoHeader: ""},
+	},
+	{
+		name:        "custom error with content type",
+		pre:         `custom_error("{\"msg\":\"denied\"}", 418, "application/json")`,
+		status:      http.StatusTeapot,
+		contentType: "application/json",
+		headers:     map[string]string{EchoHeader: ""},
+	},
+	{
+		name:    "lua error",
+		pre:     `error("boom")`,
+		status:  http.StatusInternalServerError,
+		headers: map[string]string{EchoHeader: ""},
+	},
+	{
+		name: "post",
+		pre:  `ctx.load():headers("X-Pre", "yes")`,
+		post: `local c = ctx.load()
+if c:responseStatus() ~= 200 then custom_error("unexpected status") end
+local echo = json.decode(c:responseBody())
+if echo:get("headers"):get("X-Pre") ~= "yes" then custom_error("unexpected echo") end
+c:responseStatus(201)
+c:responseHeaders("X-Post", echo:get("method"))
+c:responseHeaders("Content-Type", "text/plain")
+c:responseBody("created by " .. echo:get("method"))`,
+		status:      http.StatusCreated,
+		contentType: "text/plain",
+		headers:     map[string]string{"X-Post": "GET", EchoHeader: "true"},
+		body:        "created by GET",
+	},
+	{
+		name:    "post error",
+		post:    `custom_error("rejected", 409)`,
+		status:  http.StatusConflict,
+		headers: map[string]string{EchoHeader: "", "X-Post": ""},
+	},
+}
+
+// Run executes the conformance suite against the handlers of the builder. The
+// Decorator must be registered in the router before running it. The bodies of
+// the errors are not checked, as the routers render them in different ways.
+func Run(t *testing.T, build Builder) {
+	t.Helper()
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			extra := map[string]interface{}{}
+			if tc.pre != "" {
+				extra["pre"] = tc.pre
+			}
+			if tc.post != "" {
+				extra["post"] = tc.post
+			}
+			h := build(config.ExtraConfig{router.Namespace: extra})
+
+			req := httptest.NewRequest("GET", "http://localhost"+Path+"?id=1", strings.NewReader("original"))
+			req.Header.Set("Accept", "application/json")
+			req.Header.Set("X-To-Delete", "deleteme")
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			if w.Code != tc.status {
+				t.Errorf("unexpected status code %d: %s", w.Code, w.Body.String())
+			}
+			if tc.contentType != "" && !strings.HasPrefix(w.Header().Get("Content-Type"), tc.contentType) {
+				t.Errorf("unexpected content type: %s", w.Header().Get("Content-Type"))
+			}
+			checkHeaders(t, "response", w.Header(), tc.headers)
+			if tc.body != "" && w.Body.String() != tc.body {
+				t.Errorf("unexpected body: %s", w.Body.String())
+			}
+			if tc.echo != nil {
+				checkEcho(t, w.Body.Bytes(), tc.echo)
+			}
+		})
+	}
+}
+
+func checkEcho(t *testing.T, b []byte, expected *EchoResponse) {
+	t.Helper()
+	var echo EchoResponse
+	if err := json.Unmarshal(b, &echo); err != nil {
+		t.Errorf("unexpected response %s: %s", string(b), err.Error())
+		return
+	}
+	if echo.Method != expected.Method {
+		t.Errorf("unexpected method: %s", echo.Method)
+	}
+	if echo.URL != expected.URL {
+		t.Errorf("unexpected url: %s", echo.URL)
+	}
+	if echo.Host != expected.Host {
+		t.Errorf("unexpected host: %s", echo.Host)
+	}
+	if echo.Body != expected.Body {
+		t.Errorf("unexpected body: %s", echo.Body)
+	}
+	if len(echo.Params) != len(expected.Params) {
+		t.Errorf("unexpected params: %v", echo.Params)
+	}
+	for k, v := range expected.Params {
+		if echo.Params[k] != v {
+			t.Errorf("unexpected param %s: %s", k, echo.Params[k])
+		}
+	}
+	h := http.Header{}
+	for k, v := range echo.Headers {
+		h.Set(k, v)
+	}
+	checkHeaders(t, "request", h, expected.Headers)
+}
+
+func checkHeaders(t *testing.T, kind string, h http.Header, expected map[string]string) {
+	t.Helper()
+	for k, v := range expected {
+		if got := h.Get(k); got != v {
+			t.Errorf("unexpected %s header %s: '%s'", kind, k, got)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package lua
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"strings"
+)
+
+// signatureSuffix is appended to the name of a source to get the file with
+// its detached signature
+const signatureSuffix = ".sig"
+
+func signatureFile(source string) string {
+	return source + signatureSuffix
+}
+
+// signatureVerifier checks the detached signatures of the sources against a
+// set of trusted public keys. Ed25519 keys verify the signature of the
+// content, while ECDSA keys verify the signature of its SHA-256 digest.
+type signatureVerifier struct {
+	keys []crypto.PublicKey
+}
+
+// parseSignatures returns a verifier with the PEM encoded public keys listed
+// in the signatures block, or nil if the block is not present
+func parseSignatures(c map[string]interface{}) (*signatureVerifier, error) {
+	cfg, ok := c["signatures"].(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	keys, _ := cfg["public_keys"].([]interface{})
+	if len(keys) == 0 {
+		return nil, ErrNoPublicKeys
+	}
+
+	res := &signatureVerifier{keys: make([]crypto.PublicKey, 0, len(keys))}
+	for i, k := range keys {
+		encoded, ok := k.(string)
+		if !ok {
+			return nil, ErrWrongPublicKey(i)
+		}
+		block, _ := pem.Decode([]byte(encoded))
+		if block == nil {
+			return nil, ErrWrongPublicKey(i)
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, ErrWrongPublicKey(i)
+		}
+		switch key.(type) {
+		case ed25519.PublicKey, *ecdsa.PublicKey:
+			res.keys = append(res.keys, key)
+		default:
+			return nil, ErrWrongPublicKey(i)
+		}
+	}
+
+	return res, nil
+}
+
+func (s *signatureVerifier) verify(sources []string, loader SourceLoader) error {
+	if s == nil {
+		return nil
+	}
+	for _, source := range sources {
+		encoded, ok := loader.Get(signatureFile(source))
+		if !ok {
+			return ErrUnsignedSource(source)
+		}
+		sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return ErrWrongSignature(source)
+		}
+		content, _ := loader.Get(source)
+		if !s.valid([]byte(content), sig) {
+			return ErrWrongSignature(source)
+		}
+	}
+	return nil
+}
+
+func (s *signatureVerifier) valid(content, sig []byte) bool {
+	digest := sha256.Sum256(content)
+	for _, k := range s.keys {
+		switch key := k.(type) {
+		case ed25519.PublicKey:
+			if ed25519.Verify(key, content, sig) {
+				return true
+			}
+		case *ecdsa.PublicKey:
+			if ecdsa.VerifyASN1(key, digest[:], sig) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,179 @@
+package lua
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/logging"
+)
+
+func TestParse_signatures(t *testing.T) {
+	edPub, edPriv, _ := ed25519.GenerateKey(rand.Reader)
+	ecPriv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, untrusted, _ := ed25519.GenerateKey(rand.Reader)
+
+	content := []byte(`function foo() return 1 end`)
+	edSign := func(b []byte) []byte { return ed25519.Sign(edPriv, b) }
+	ecSign := func(b []byte) []byte {
+		digest := sha256.Sum256(b)
+		sig, _ := ecdsa.SignASN1(rand.Reader, ecPriv, digest[:])
+		return sig
+	}
+
+	for _, tc := range []struct {
+		Name          string
+		Keys          []interface{}
+		Signature     []byte
+		Content       []byte
+		ExpectedError error
+	}{
+		{
+			Name:      "ed25519",
+			Keys:      []interface{}{encodePublicKey(t, edPub)},
+			Signature: edSign(content),
+		},
+		{
+			Name:      "ecdsa",
+			Keys:      []interface{}{encodePublicKey(t, edPub), encodePublicKey(t, &ecPriv.PublicKey)},
+			Signature: ecSign(content),
+		},
+		{
+			Name:          "unsigned",
+			Keys:          []interface{}{encodePublicKey(t, edPub)},
+			ExpectedError: ErrUnsignedSource("source.lua"),
+		},
+		{
+			Name:          "tampered",
+			Keys:          []interface{}{encodePublicKey(t, edPub)},
+			Signature:     edSign(content),
+			Content:       []byte(`function foo() return 2 end`),
+			ExpectedError: ErrWrongSignature("source.lua"),
+		},
+		{
+			Name:          "untrusted key",
+			Keys:          []interface{}{encodePublicKey(t, &ecPriv.PublicKey)},
+			Signature:     ed25519.Sign(untrusted, content),
+			ExpectedError: ErrWrongSignature("source.lua"),
+		},
+		{
+			Name:          "no keys",
+			ExpectedError: ErrNoPublicKeys,
+		},
+		{
+			Name:          "wrong key",
+			Keys:          []interface{}{encodePublicKey(t, edPub), "not a key"},
+			ExpectedError: ErrWrongPublicKey(1),
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			dir := t.TempDir()
+			source := filepath.Join(dir, "source.lua")
+
+			c := tc.Content
+			if c == nil {
+				c = content
+			}
+			if err := os.WriteFile(source, c, 0644); err != nil {
+				t.Error(err)
+				return
+			}
+			if tc.Signature != nil {
+				if err := os.WriteFile(signatureFile(source), []byte(base64.StdEncoding.EncodeToString(tc.Signature)), 0644); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+
+			in := config.ExtraConfig{
+				"1234": map[string]interface{}{
+					"sources":    []interface{}{source},
+					"signatures": map[string]interface{}{"public_keys": tc.Keys},
+				},
+			}
+			_, err := Parse(logging.NoOp, in, "1234")
+			if expected := renameSource(tc.ExpectedError, source); err != expected {
+				t.Errorf("unexpected error, have: %v, want: %v", err, expected)
+			}
+		})
+	}
+}
+
+func TestWatchLoader_signatures(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source.lua")
+	write := func(content string, sig []byte) {
+		if err := os.WriteFile(signatureFile(source), []byte(base64.StdEncoding.EncodeToString(sig)), 0644); err != nil {
+			t.Error(err)
+		}
+		if err := os.WriteFile(source, []byte(content), 0644); err != nil {
+			t.Error(err)
+		}
+	}
+	write(`function foo() return 1 end`, ed25519.Sign(priv, []byte(`function foo() return 1 end`)))
+
+	buf := new(syncBuffer)
+	l, _ := logging.NewLogger("DEBUG", buf, "")
+
+	in := config.ExtraConfig{
+		"1234": map[string]interface{}{
+			"sources":    []interface{}{source},
+			"signatures": map[string]interface{}{"public_keys": []interface{}{encodePublicKey(t, pub)}},
+			"live":       true,
+		},
+	}
+	cfg, err := Parse(l, in, "1234")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	defer closeLoader(cfg)
+
+	write(`function foo() return 2 end`, ed25519.Sign(priv, []byte(`function foo() return 2 end`)))
+	if !eventually(func() bool {
+		src, _ := cfg.Get(source)
+		return src == `function foo() return 2 end`
+	}) {
+		t.Errorf("the signed source was not reloaded: %s", buf.String())
+	}
+
+	buf.Reset()
+	write(`function foo() return 3 end`, ed25519.Sign(priv, []byte(`function foo() return 2 end`)))
+	if !eventually(func() bool { return strings.Contains(buf.String(), ErrWrongSignature(source).Error()) }) {
+		t.Errorf("unexpected log: %s", buf.String())
+	}
+	if src, _ := cfg.Get(source); src != `function foo() return 2 end` {
+		t.Errorf("wrong content %s", src)
+	}
+}
+
+func encodePublicKey(t *testing.T, key crypto.PublicKey) string {
+	b, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: b}))
+}
+
+func renameSource(err error, source string) error {
+	switch err.(type) {
+	case ErrUnsignedSource:
+		return ErrUnsignedSource(source)
+	case ErrWrongSignature:
+		return ErrWrongSignature(source)
+	}
+	return err
+}
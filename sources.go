@@ -0,0 +1,83 @@
+package lua
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const globMeta = "*?["
+
+// isSourcePattern returns true if the entry of the sources list can match
+// several files: a directory or a glob pattern
+func isSourcePattern(entry string) bool {
+	if strings.ContainsAny(entry, globMeta) {
+		return true
+	}
+	info, err := os.Stat(entry)
+	return err == nil && info.IsDir()
+}
+
+// expandSources returns the files of the sources list, replacing the
+// directories by the lua files they contain and the glob patterns by the files
+// matching them. The files of each entry are sorted and the duplicates skipped.
+func expandSources(entries []string) ([]string, error) {
+	res := make([]string, 0, len(entries))
+	seen := map[string]struct{}{}
+
+	for _, entry := range entries {
+		files, err := expandSource(entry)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			if _, ok := seen[file]; ok {
+				continue
+			}
+			seen[file] = struct{}{}
+			res = append(res, file)
+		}
+	}
+
+	return res, nil
+}
+
+func expandSource(entry string) ([]string, error) {
+	if strings.ContainsAny(entry, globMeta) {
+		files, err := filepath.Glob(entry)
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(files)
+		return files, nil
+	}
+
+	info, err := os.Stat(entry)
+	if err != nil || !info.IsDir() {
+		return []string{entry}, nil
+	}
+
+	entries, err := os.ReadDir(entry)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		if !e.IsDir() && filepath.Ext(e.Name()) == ".lua" {
+			files = append(files, filepath.Join(entry, e.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// matchesSource returns true if the file would be part of the expansion of the
+// entry of the sources list
+func matchesSource(entry, file string) bool {
+	if strings.ContainsAny(entry, globMeta) {
+		ok, _ := filepath.Match(entry, file)
+		return ok
+	}
+	return filepath.Dir(file) == entry && filepath.Ext(file) == ".lua"
+}
@@ -0,0 +1,121 @@
+package lua
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/logging"
+)
+
+func TestParse_sourcePatterns(t *testing.T) {
+	dir := t.TempDir()
+	for _, file := range []string{"lib/b.lua", "lib/a.lua", "lib/notes.txt", "lib/nested/c.lua", "extra/x_1.lua", "extra/x_2.lua", "extra/y.lua"} {
+		writeSource(t, filepath.Join(dir, file), "local a = 1")
+	}
+
+	in := config.ExtraConfig{
+		"1234": map[string]interface{}{
+			"sources": []interface{}{
+				filepath.Join(dir, "lib"),
+				filepath.Join(dir, "extra", "x_*.lua"),
+				filepath.Join(dir, "lib", "a.lua"),
+			},
+		},
+	}
+	cfg, err := Parse(logging.NoOp, in, "1234")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+
+	expected := []string{
+		filepath.Join(dir, "lib", "a.lua"),
+		filepath.Join(dir, "lib", "b.lua"),
+		filepath.Join(dir, "extra", "x_1.lua"),
+		filepath.Join(dir, "extra", "x_2.lua"),
+	}
+	if !slices.Equal(cfg.Sources, expected) {
+		t.Errorf("unexpected sources: %v", cfg.Sources)
+	}
+
+	p, err := cfg.Program()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(p.SourceMap) != len(expected) {
+		t.Errorf("unexpected source map: %v", p.SourceMap)
+		return
+	}
+	for i, s := range p.SourceMap {
+		if s.Path != expected[i] {
+			t.Errorf("unexpected source map entry #%d: %s", i, s.Path)
+		}
+	}
+}
+
+func TestParse_wrongSourcePattern(t *testing.T) {
+	in := config.ExtraConfig{
+		"1234": map[string]interface{}{
+			"sources": []interface{}{"lua/[.lua"},
+		},
+	}
+	if _, err := Parse(logging.NoOp, in, "1234"); err != filepath.ErrBadPattern {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestWatchLoader_sourcePatterns(t *testing.T) {
+	dir := t.TempDir()
+	writeSource(t, filepath.Join(dir, "a.lua"), "function a() return 1 end")
+
+	in := config.ExtraConfig{
+		"1234": map[string]interface{}{
+			"sources": []interface{}{dir},
+			"live":    true,
+		},
+	}
+	cfg, err := Parse(logging.NoOp, in, "1234")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	defer closeLoader(cfg)
+
+	sourcesOf := func() []string {
+		p, err := cfg.Program()
+		if err != nil {
+			return nil
+		}
+		res := make([]string, len(p.SourceMap))
+		for i, s := range p.SourceMap {
+			res[i] = filepath.Base(s.Path)
+		}
+		return res
+	}
+
+	writeSource(t, filepath.Join(dir, "b.lua"), "function b() return 2 end")
+	if !eventually(func() bool { return slices.Equal(sourcesOf(), []string{"a.lua", "b.lua"}) }) {
+		t.Errorf("the added file was not loaded: %v", sourcesOf())
+	}
+
+	if err := os.Remove(filepath.Join(dir, "a.lua")); err != nil {
+		t.Error(err)
+		return
+	}
+	if !eventually(func() bool { return slices.Equal(sourcesOf(), []string{"b.lua"}) }) {
+		t.Errorf("the removed file is still loaded: %v", sourcesOf())
+	}
+}
+
+func writeSource(t *testing.T, file, content string) {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,82 @@
+package lua
+
+import (
+	"errors"
+	"time"
+)
+
+// The stages of the scripts recorded by the execution metrics
+const (
+	StagePre     = "pre"
+	StagePost    = "post"
+	StageSources = "sources"
+)
+
+// ExecutionMetrics records how the scripts of the configs perform. The
+// endpoint is the one of the config (or the url pattern for the backends)
+type ExecutionMetrics interface {
+	ObserveStage(endpoint, stage string, d time.Duration)
+	CountError(endpoint, stage, errType string)
+	CountVM(endpoint string)
+}
+
+// ExecutionMetricsRegistry collects the execution metrics of the configs using
+// the DefaultExecutionMetrics. It is not shared with the scripts and it does
+// not limit the series, as their labels come from the configs
+var ExecutionMetricsRegistry = newMetricsRegistry(0)
+
+// DefaultExecutionMetrics is used by the configs not defining their own
+// ExecutionMetrics. Replace it in order to send the metrics of all the configs
+// parsed by the factories somewhere else
+var DefaultExecutionMetrics ExecutionMetrics = NewExecutionMetrics(ExecutionMetricsRegistry)
+
+// NewExecutionMetrics returns an ExecutionMetrics recording the metrics in the
+// registry. The observations the registry rejects are counted in the
+// krakend_lua_metrics_dropped_total counter, labeled with the metric name
+func NewExecutionMetrics(r *MetricsRegistry) ExecutionMetrics {
+	return registryMetrics{r}
+}
+
+type registryMetrics struct {
+	registry *MetricsRegistry
+}
+
+func (m registryMetrics) ObserveStage(endpoint, stage string, d time.Duration) {
+	m.check("krakend_lua_stage_duration_seconds", m.registry.Histogram("krakend_lua_stage_duration_seconds", d.Seconds(), map[string]string{"endpoint": endpoint, "stage": stage}))
+}
+
+func (m registryMetrics) CountError(endpoint, stage, errType string) {
+	m.check("krakend_lua_errors_total", m.registry.Counter("krakend_lua_errors_total", 1, map[string]string{"endpoint": endpoint, "stage": stage, "type": errType}))
+}
+
+func (m registryMetrics) CountVM(endpoint string) {
+	m.check("krakend_lua_vms_created_total", m.registry.Counter("krakend_lua_vms_created_total", 1, map[string]string{"endpoint": endpoint}))
+}
+
+func (m registryMetrics) check(name string, err error) {
+	if err != nil {
+		m.registry.Counter("krakend_lua_metrics_dropped_total", 1, map[string]string{"metric": name})
+	}
+}
+
+// ErrorType classifies the errors returned by the scripts
+func ErrorType(err error) string {
+	var (
+		internal     ErrInternal
+		internalHTTP ErrInternalHTTP
+		withType     ErrInternalHTTPWithContentType
+	)
+	switch {
+	case err == ErrScriptTimeout:
+		return "timeout"
+	case err == ErrScriptBudgetExceeded:
+		return "budget"
+	case err == ErrMemoryLimit:
+		return "memory"
+	case errors.As(err, &withType), errors.As(err, &internalHTTP):
+		return "ErrInternalHTTP"
+	case errors.As(err, &internal):
+		return "ErrInternal"
+	}
+	return "other"
+}
@@ -0,0 +1,120 @@
+package lua
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordedMetrics struct {
+	mu     sync.Mutex
+	stages map[string]int
+	errors map[string]int
+	vms    map[string]int
+}
+
+func newRecordedMetrics() *recordedMetrics {
+	return &recordedMetrics{stages: map[string]int{}, errors: map[string]int{}, vms: map[string]int{}}
+}
+
+func (r *recordedMetrics) ObserveStage(endpoint, stage string, _ time.Duration) {
+	r.mu.Lock()
+	r.stages[endpoint+" "+stage]++
+	r.mu.Unlock()
+}
+
+func (r *recordedMetrics) CountError(endpoint, stage, errType string) {
+	r.mu.Lock()
+	r.errors[endpoint+" "+stage+" "+errType]++
+	r.mu.Unlock()
+}
+
+func (r *recordedMetrics) CountVM(endpoint string) {
+	r.mu.Lock()
+	r.vms[endpoint]++
+	r.mu.Unlock()
+}
+
+func TestPool_executionMetrics(t *testing.T) {
+	stats := newRecordedMetrics()
+	cfg := &Config{
+		Sources:          []string{"lua/init.lua"},
+		SourceLoader:     onceLoader{"lua/init.lua": "counter = 0"},
+		PreCode:          "counter = counter + 1\nif fail then error('boom') end\nwhile loop do end",
+		PostCode:         "counter = counter + 1",
+		Pool:             PoolConfig{MaxIdle: 1},
+		Limits:           Limits{Timeout: 10 * time.Millisecond},
+		Endpoint:         "/foo",
+		ExecutionMetrics: stats,
+	}
+	if _, err := cfg.Program(); err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewPool(cfg, func(*VM) {})
+	for _, code := range []string{"", "fail = true", "loop = true", ""} {
+		vm, err := p.Get(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := vm.WithCode("setup", code); err != nil {
+			t.Error(err)
+		}
+		if err := vm.Pre(); err == nil {
+			vm.Post()
+		}
+		p.Put(vm)
+	}
+
+	for k, v := range map[string]int{"/foo sources": 2, "/foo pre": 4, "/foo post": 2} {
+		if stats.stages[k] != v {
+			t.Errorf("unexpected number of %s stages: %d", k, stats.stages[k])
+		}
+	}
+	for k, v := range map[string]int{"/foo pre ErrInternal": 1, "/foo pre timeout": 1} {
+		if stats.errors[k] != v {
+			t.Errorf("unexpected number of %s errors: %d. have: %v", k, stats.errors[k], stats.errors)
+		}
+	}
+	// the VM timing out is not reused
+	if stats.vms["/foo"] != 2 {
+		t.Errorf("unexpected number of VMs created: %d", stats.vms["/foo"])
+	}
+}
+
+func TestExecutionMetrics_dropped(t *testing.T) {
+	registry := newMetricsRegistry(1)
+	stats := NewExecutionMetrics(registry)
+	for _, endpoint := range []string{"/foo", "/bar", "/baz"} {
+		stats.CountVM(endpoint)
+	}
+
+	e := &MemoryExporter{}
+	registry.Export(e)
+
+	if m, ok := e.Get("krakend_lua_vms_created_total", map[string]string{"endpoint": "/foo"}); !ok || m.Value != 1 {
+		t.Errorf("unexpected metric: %+v", m)
+	}
+	m, ok := e.Get("krakend_lua_metrics_dropped_total", map[string]string{"metric": "krakend_lua_vms_created_total"})
+	if !ok || m.Value != 2 {
+		t.Errorf("unexpected dropped metric: %+v", m)
+	}
+}
+
+func TestDefaultExecutionMetrics_registry(t *testing.T) {
+	if ExecutionMetricsRegistry == DefaultMetricsRegistry {
+		t.Error("the execution metrics share the registry of the scripts")
+	}
+	if ExecutionMetricsRegistry.maxSeries > 0 {
+		t.Errorf("unexpected limit of series: %d", ExecutionMetricsRegistry.maxSeries)
+	}
+
+	registry := newMetricsRegistry(0)
+	for i := 0; i < 2*DefaultMaxSeries; i++ {
+		if err := registry.Counter("unbounded_total", 1, map[string]string{"i": strconv.Itoa(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
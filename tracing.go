@@ -0,0 +1,47 @@
+package lua
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName is the name of the tracer creating the spans of the scripts
+const TracerName = "github.com/krakend/krakend-lua/v2"
+
+// StartSpan starts a child span of the one in the context, using the global
+// tracer provider
+func StartSpan(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.GetTracerProvider().Tracer(TracerName).Start(ctx, name, opts...)
+}
+
+// EndSpan records the error, if any, and ends the span
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func stageSpan(ctx context.Context, endpoint, stage string) (context.Context, trace.Span) {
+	return StartSpan(ctx, "lua "+stage, trace.WithAttributes(
+		attribute.String("lua.endpoint", endpoint),
+		attribute.String("lua.stage", stage),
+	))
+}
+
+// InjectTraceHeaders adds the headers propagating the span of the context. It
+// uses the global propagator or, if none is defined, the W3C trace context one
+func InjectTraceHeaders(ctx context.Context, h http.Header) {
+	p := otel.GetTextMapPropagator()
+	if len(p.Fields()) == 0 {
+		p = propagation.TraceContext{}
+	}
+	p.Inject(ctx, propagation.HeaderCarrier(h))
+}
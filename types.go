@@ -40,9 +40,10 @@ type List struct {
 }
 
 type HttpResponse struct {
-	Once *sync.Once
-	R    *http.Response
-	body string
+	Once    *sync.Once
+	R       *http.Response
+	body    string
+	charged bool
 }
 
 func (h *HttpResponse) Close() {
@@ -55,12 +56,31 @@ func (h *HttpResponse) Close() {
 }
 
 func (h *HttpResponse) Body() string {
+	h.Read()
+	return h.body
+}
+
+// Read reads the body, returning the error interrupting it. Only the first call
+// reads it, so the error is not returned again
+func (h *HttpResponse) Read() error {
+	var err error
 	h.Once.Do(func() {
-		b, _ := io.ReadAll(h.R.Body)
+		var b []byte
+		b, err = io.ReadAll(h.R.Body)
 		h.Close()
 		h.body = string(b)
 	})
-	return h.body
+	return err
+}
+
+// ReadBody returns the body, charging its size to the budget the first time
+func (h *HttpResponse) ReadBody(budget *DataBudget) (string, error) {
+	body := h.Body()
+	if h.charged {
+		return body, nil
+	}
+	h.charged = true
+	return body, budget.Consume(len(body))
 }
 
 func (h *HttpResponse) Header(k string) string {
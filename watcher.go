@@ -0,0 +1,326 @@
+package lua
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/luraproject/lura/v2/logging"
+)
+
+// reloadDelay is the time to wait after the last change of a source before
+// reloading them, so partial writes are not loaded
+var reloadDelay = 100 * time.Millisecond
+
+// watchLoader is a SourceLoader caching the content of the sources and
+// reloading them when their files change. The new versions are served only
+// after verifying and compiling them, so the last good version is kept on
+// failures. The directories and glob patterns of the sources are expanded
+// again on every reload, so added and removed files are picked up.
+type watchLoader struct {
+	cfg      Config
+	watched  map[string]struct{}
+	patterns []string
+	state    atomic.Pointer[watchState]
+	l        logging.Logger
+
+	mu    sync.Mutex
+	timer *time.Timer
+}
+
+type watchState struct {
+	sources  []string
+	contents onceLoader
+}
+
+// newLiveLoader returns a watchLoader for the sources of the config or a
+// loader reading the files on every call if the files can not be watched
+func newLiveLoader(l logging.Logger, cfg *Config) SourceLoader {
+	w, err := newWatchLoader(l, cfg)
+	if err != nil {
+		l.Warning("[Lua] Watching the sources:", err.Error(), "Reading them on every request instead")
+		return liveLoader{}
+	}
+	return w
+}
+
+func newWatchLoader(l logging.Logger, cfg *Config) (*watchLoader, error) {
+	w := &watchLoader{
+		cfg:     *cfg,
+		watched: map[string]struct{}{},
+		l:       l,
+	}
+	w.cfg.SourceLoader = nil
+	w.cfg.programs = nil
+
+	contents := onceLoader{}
+	dirs := map[string]struct{}{}
+	for _, name := range cfg.files() {
+		if b, err := os.ReadFile(name); err != nil {
+			l.Error("[Lua] Opening the source file:", err.Error())
+		} else {
+			contents[name] = string(b)
+		}
+
+		abs, err := filepath.Abs(name)
+		if err != nil {
+			return nil, err
+		}
+		w.watched[abs] = struct{}{}
+		dirs[filepath.Dir(abs)] = struct{}{}
+	}
+
+	for _, entry := range cfg.sourcePatterns {
+		if !isSourcePattern(entry) {
+			continue
+		}
+		abs, err := filepath.Abs(entry)
+		if err != nil {
+			return nil, err
+		}
+		w.patterns = append(w.patterns, abs)
+		if !strings.ContainsAny(abs, globMeta) {
+			dirs[abs] = struct{}{}
+		} else if dir := filepath.Dir(abs); !strings.ContainsAny(dir, globMeta) {
+			dirs[dir] = struct{}{}
+		}
+	}
+
+	w.state.Store(&watchState{sources: cfg.Sources, contents: contents})
+
+	// the directories are watched instead of the files, so the sources
+	// replaced by editors and deployment tools are still tracked
+	list := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		list = append(list, dir)
+	}
+	if err := sharedWatcher.subscribe(w, list); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (w *watchLoader) Get(k string) (string, bool) {
+	return w.state.Load().contents.Get(k)
+}
+
+// snapshot returns the current list of sources and a loader with their content
+func (w *watchLoader) snapshot() ([]string, SourceLoader) {
+	s := w.state.Load()
+	return s.sources, s.contents
+}
+
+// Close stops watching the sources
+func (w *watchLoader) Close() error {
+	w.mu.Lock()
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.mu.Unlock()
+	return sharedWatcher.unsubscribe(w)
+}
+
+func (w *watchLoader) handle(event fsnotify.Event) {
+	if event.Has(fsnotify.Chmod) || !w.relevant(filepath.Clean(event.Name)) {
+		return
+	}
+	w.scheduleReload()
+}
+
+func (w *watchLoader) relevant(name string) bool {
+	if _, ok := w.watched[name]; ok {
+		return true
+	}
+	name = strings.TrimSuffix(name, signatureSuffix)
+	for _, pattern := range w.patterns {
+		if matchesSource(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *watchLoader) scheduleReload() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.timer = time.AfterFunc(reloadDelay, w.reload)
+}
+
+func (w *watchLoader) reload() {
+	cfg := w.cfg
+	if len(cfg.sourcePatterns) > 0 {
+		sources, err := expandSources(cfg.sourcePatterns)
+		if err != nil {
+			w.l.Error("[Lua] Reloading the sources:", err.Error(), "Keeping the previous version")
+			return
+		}
+		cfg.Sources = sources
+	}
+
+	contents := onceLoader{}
+	for _, name := range cfg.files() {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			w.l.Error("[Lua] Reloading the sources:", err.Error(), "Keeping the previous version")
+			return
+		}
+		contents[name] = string(b)
+	}
+
+	if err := cfg.verify(contents); err != nil {
+		w.l.Error("[Lua] Reloading the sources:", err.Error(), "Keeping the previous version")
+		return
+	}
+	files := cfg.sourceFiles()
+	ordered := make([]string, len(files))
+	for i, file := range files {
+		ordered[i] = contents[file]
+	}
+	if _, err := compileProgram(&cfg, ordered); err != nil {
+		w.l.Error("[Lua] Reloading the sources:", err.Error(), "Keeping the previous version")
+		return
+	}
+
+	w.state.Store(&watchState{sources: cfg.Sources, contents: contents})
+	w.l.Info("[Lua] Sources reloaded:", strings.Join(files, ", "))
+}
+
+// sharedWatcher is the fsnotify watcher used by all the live configs, so the
+// process holds a single inotify instance regardless of the number of configs
+var sharedWatcher = &sourceWatcher{}
+
+// StopWatching stops watching the sources of all the live configs. Their
+// loaders keep serving the last loaded version of the sources. It is meant to
+// be called when shutting down the gateway; a single config can be released
+// with Config.Close
+func StopWatching() {
+	sharedWatcher.stop()
+}
+
+// sourceWatcher dispatches the events of the watched directories to the
+// subscribed loaders, which filter the ones related to their sources. The
+// directories are reference counted, and the underlying watcher is closed
+// when there are no subscribers left.
+type sourceWatcher struct {
+	mu          sync.Mutex
+	watcher     *fsnotify.Watcher
+	dirs        map[string]int
+	subscribers map[*watchLoader][]string
+}
+
+func (s *sourceWatcher) subscribe(w *watchLoader, dirs []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.watcher == nil {
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			return err
+		}
+		s.watcher = watcher
+		s.dirs = map[string]int{}
+		s.subscribers = map[*watchLoader][]string{}
+		go s.run(watcher)
+	}
+
+	for i, dir := range dirs {
+		if s.dirs[dir] == 0 {
+			if err := s.watcher.Add(dir); err != nil {
+				s.release(dirs[:i])
+				s.closeIfIdle()
+				return err
+			}
+		}
+		s.dirs[dir]++
+	}
+	s.subscribers[w] = dirs
+	return nil
+}
+
+func (s *sourceWatcher) unsubscribe(w *watchLoader) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dirs, ok := s.subscribers[w]
+	if !ok {
+		return nil
+	}
+	delete(s.subscribers, w)
+	s.release(dirs)
+	return s.closeIfIdle()
+}
+
+func (s *sourceWatcher) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.watcher == nil {
+		return
+	}
+	s.watcher.Close()
+	s.watcher = nil
+	s.dirs = nil
+	s.subscribers = nil
+}
+
+// release stops watching the directories not used by any other subscriber
+func (s *sourceWatcher) release(dirs []string) {
+	for _, dir := range dirs {
+		s.dirs[dir]--
+		if s.dirs[dir] > 0 {
+			continue
+		}
+		delete(s.dirs, dir)
+		s.watcher.Remove(dir)
+	}
+}
+
+func (s *sourceWatcher) closeIfIdle() error {
+	if len(s.subscribers) > 0 {
+		return nil
+	}
+	err := s.watcher.Close()
+	s.watcher = nil
+	return err
+}
+
+func (s *sourceWatcher) loaders() []*watchLoader {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := make([]*watchLoader, 0, len(s.subscribers))
+	for w := range s.subscribers {
+		res = append(res, w)
+	}
+	return res
+}
+
+func (s *sourceWatcher) run(watcher *fsnotify.Watcher) {
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			for _, w := range s.loaders() {
+				w.handle(event)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			for _, w := range s.loaders() {
+				w.l.Error("[Lua] Watching the sources:", err.Error())
+			}
+		}
+	}
+}
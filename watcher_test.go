@@ -0,0 +1,247 @@
+package lua
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/logging"
+)
+
+func TestWatchLoader(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source.lua")
+	if err := os.WriteFile(source, []byte(`function foo() return 1 end`), 0644); err != nil {
+		t.Error(err)
+		return
+	}
+
+	buf := new(syncBuffer)
+	l, _ := logging.NewLogger("DEBUG", buf, "")
+
+	in := config.ExtraConfig{
+		"1234": map[string]interface{}{
+			"sources": []interface{}{source},
+			"live":    true,
+		},
+	}
+	cfg, err := Parse(l, in, "1234")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	defer closeLoader(cfg)
+
+	if _, ok := cfg.SourceLoader.(*watchLoader); !ok {
+		t.Errorf("unexpected loader: %T", cfg.SourceLoader)
+		return
+	}
+
+	for _, tc := range []struct {
+		name     string
+		write    func() error
+		expected string
+		log      string
+	}{
+		{
+			name:     "write",
+			write:    func() error { return os.WriteFile(source, []byte(`function foo() return 2 end`), 0644) },
+			expected: `function foo() return 2 end`,
+			log:      "Sources reloaded",
+		},
+		{
+			name:     "syntax error",
+			write:    func() error { return os.WriteFile(source, []byte(`function foo() retrun 3 end`), 0644) },
+			expected: `function foo() return 2 end`,
+			log:      "Keeping the previous version",
+		},
+		{
+			name: "replace",
+			write: func() error {
+				tmp := filepath.Join(dir, "source.tmp")
+				if err := os.WriteFile(tmp, []byte(`function foo() return 4 end`), 0644); err != nil {
+					return err
+				}
+				return os.Rename(tmp, source)
+			},
+			expected: `function foo() return 4 end`,
+			log:      "Sources reloaded",
+		},
+		{
+			name:     "remove",
+			write:    func() error { return os.Remove(source) },
+			expected: `function foo() return 4 end`,
+			log:      "Keeping the previous version",
+		},
+	} {
+		buf.Reset()
+		if err := tc.write(); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			return
+		}
+
+		if !eventually(func() bool { return strings.Contains(buf.String(), tc.log) }) {
+			t.Errorf("%s: unexpected log: %s", tc.name, buf.String())
+		}
+		if src, ok := cfg.Get(source); !ok || src != tc.expected {
+			t.Errorf("%s: wrong content %s", tc.name, src)
+		}
+	}
+}
+
+func TestWatchLoader_checksums(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source.lua")
+	if err := os.WriteFile(source, []byte(`function foo() return 1 end`), 0644); err != nil {
+		t.Error(err)
+		return
+	}
+
+	buf := new(syncBuffer)
+	l, _ := logging.NewLogger("DEBUG", buf, "")
+
+	in := config.ExtraConfig{
+		"1234": map[string]interface{}{
+			"sources": []interface{}{source},
+			"checksums": map[string]interface{}{
+				source: "sha256:03eab5d3871b62da0434b9ae4e8879333c4b56f41fd30968384c4109dbbeeff5",
+			},
+			"live": true,
+		},
+	}
+	cfg, err := Parse(l, in, "1234")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	defer closeLoader(cfg)
+
+	if err := os.WriteFile(source, []byte(`function foo() return 2 end`), 0644); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !eventually(func() bool { return strings.Contains(buf.String(), "wrong sha256 cheksum") }) {
+		t.Errorf("unexpected log: %s", buf.String())
+	}
+	if src, _ := cfg.Get(source); src != `function foo() return 1 end` {
+		t.Errorf("wrong content %s", src)
+	}
+}
+
+func TestWatchLoader_shared(t *testing.T) {
+	dir := t.TempDir()
+	var cfgs []Config
+	for _, name := range []string{"a.lua", "b.lua"} {
+		source := filepath.Join(dir, name)
+		if err := os.WriteFile(source, []byte(`function foo() return 1 end`), 0644); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := Parse(logging.NoOp, config.ExtraConfig{
+			"1234": map[string]interface{}{
+				"sources": []interface{}{source},
+				"live":    true,
+			},
+		}, "1234")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfgs = append(cfgs, cfg)
+	}
+
+	sharedWatcher.mu.Lock()
+	watcher, subscribers, refs := sharedWatcher.watcher, len(sharedWatcher.subscribers), sharedWatcher.dirs[dir]
+	sharedWatcher.mu.Unlock()
+	if watcher == nil || subscribers != 2 || refs != 2 {
+		t.Errorf("unexpected state of the watcher: %v %d %d", watcher, subscribers, refs)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "b.lua"), []byte(`function foo() return 2 end`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !eventually(func() bool {
+		src, _ := cfgs[1].Get(filepath.Join(dir, "b.lua"))
+		return src == `function foo() return 2 end`
+	}) {
+		t.Error("the second config was not reloaded")
+	}
+
+	for _, cfg := range cfgs {
+		if err := cfg.Close(); err != nil {
+			t.Error(err)
+		}
+	}
+
+	sharedWatcher.mu.Lock()
+	defer sharedWatcher.mu.Unlock()
+	if sharedWatcher.watcher != nil || len(sharedWatcher.subscribers) != 0 {
+		t.Error("the watcher was not closed")
+	}
+}
+
+func TestParse_liveError(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "source.lua")
+	if err := os.WriteFile(source, []byte(`function foo() retrun 1 end`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Parse(logging.NoOp, config.ExtraConfig{
+		"1234": map[string]interface{}{
+			"sources": []interface{}{source},
+			"live":    true,
+		},
+	}, "1234")
+	if err == nil {
+		t.Error("error expected")
+	}
+
+	sharedWatcher.mu.Lock()
+	defer sharedWatcher.mu.Unlock()
+	if len(sharedWatcher.subscribers) != 0 {
+		t.Errorf("unexpected subscribers: %d", len(sharedWatcher.subscribers))
+	}
+}
+
+func eventually(f func() bool) bool {
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if f() {
+			return true
+		}
+	}
+	return false
+}
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func (b *syncBuffer) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf.Reset()
+}
+
+func closeLoader(cfg Config) {
+	if c, ok := cfg.SourceLoader.(io.Closer); ok {
+		c.Close()
+	}
+}